@@ -0,0 +1,475 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "fmt"
+  "sync"
+  "time"
+  "errors"
+  "context"
+  "encoding/json"
+)
+
+var CompareAndSwapUnsupportedError = errors.New("Configuration does not support compare-and-swap")
+var AuditFailedError = errors.New("Change could not be audited")
+
+/**
+ * Audited operations which are not otherwise defined
+ */
+const (
+  OpCompareAndSwap  = "compareAndSwap"
+)
+
+/**
+ * Context key
+ */
+type actorContextKey struct{}
+
+/**
+ * Obtain a context which identifies the actor making changes, which is recorded when
+ * changes are audited.
+ */
+func WithActor(parent context.Context, actor string) context.Context {
+  return context.WithValue(parent, actorContextKey{}, actor)
+}
+
+/**
+ * Obtain the actor identified by a context
+ */
+func ActorFromContext(ctx context.Context) (string, bool) {
+  a, ok := ctx.Value(actorContextKey{}).(string)
+  return a, ok
+}
+
+/**
+ * A record of a change. The old value is the value read immediately before the change
+ * was made. The index is the index which resulted from the change, where the underlying
+ * configuration provides one. A pending record describes a change which is about to be
+ * attempted (see AuditOptions.FailClosed); its outcome is described by a later record.
+ */
+type AuditRecord struct {
+  Time      time.Time   `json:"time"`
+  Actor     string      `json:"actor,omitempty"`
+  Op        string      `json:"op"`
+  Key       string      `json:"key"`
+  Old       interface{} `json:"old,omitempty"`
+  New       interface{} `json:"new,omitempty"`
+  Index     int64       `json:"index,omitempty"`
+  Error     string      `json:"error,omitempty"`
+  Pending   bool        `json:"pending,omitempty"`
+}
+
+/**
+ * A destination for audit records
+ */
+type AuditSink interface {
+  Record(r *AuditRecord) error
+}
+
+/**
+ * A sink which writes records as JSON, one per line
+ */
+type jsonAuditSink struct {
+  sync.Mutex
+  writer    io.Writer
+}
+
+/**
+ * Create a sink which writes records to the provided writer as JSON, one per line
+ */
+func NewJSONAuditSink(w io.Writer) AuditSink {
+  return &jsonAuditSink{writer:w}
+}
+
+/**
+ * Record a change
+ */
+func (s *jsonAuditSink) Record(r *AuditRecord) error {
+  data, err := json.Marshal(r)
+  if err != nil {
+    return err
+  }
+  s.Lock()
+  defer s.Unlock()
+  _, err = s.writer.Write(append(data, '\n'))
+  return err
+}
+
+/**
+ * A sink which stores records in a configuration
+ */
+type configAuditSink struct {
+  sync.Mutex
+  config    Config
+  prefix    string
+  last      int64
+}
+
+/**
+ * Create a sink which stores records in a configuration under the provided prefix. Each
+ * record is stored as JSON under a key derived from the time it was made, so records
+ * are ordered by their keys.
+ */
+func NewConfigAuditSink(config Config, prefix string) AuditSink {
+  return &configAuditSink{config:config, prefix:prefix}
+}
+
+/**
+ * Record a change
+ */
+func (s *configAuditSink) Record(r *AuditRecord) error {
+  data, err := json.Marshal(r)
+  if err != nil {
+    return err
+  }
+  
+  // make sure keys are unique even when records are made in quick succession
+  s.Lock()
+  n := r.Time.UnixNano()
+  if n <= s.last {
+    n = s.last + 1
+  }
+  s.last = n
+  s.Unlock()
+  
+  _, err = s.config.Set(fmt.Sprintf("%s.%019d", s.prefix, n), string(data))
+  return err
+}
+
+/**
+ * Implemented by configurations which provide the index resulting from a change
+ */
+type indexSetter interface {
+  SetWithIndex(key string, value interface{}) (interface{}, int64, error)
+}
+
+/**
+ * Implemented by configurations which provide the index resulting from a delete
+ */
+type indexDeleter interface {
+  DeleteWithIndex(key string) (int64, error)
+}
+
+/**
+ * Implemented by configurations which support compare-and-swap
+ */
+type compareAndSwapper interface {
+  CompareAndSwap(key string, value interface{}, prev int64) (interface{}, int64, error)
+}
+
+/**
+ * Options for an audited configuration
+ */
+type AuditOptions struct {
+  
+  /**
+   * Whether changes are refused when they can't be recorded. By default a change is made
+   * and then recorded, and if the record can't be written the change stands. When this
+   * is set, a pending record is written before each change is attempted and the change
+   * is refused with AuditFailedError if it can't be; the outcome is recorded afterwards
+   * as usual.
+   */
+  FailClosed    bool
+  
+  /**
+   * Called when a record can't be written. If this is not provided, the failure is
+   * written to the logger.
+   */
+  OnError       func(*AuditRecord, error)
+  
+  /**
+   * The logger to which failures are written if there is no error handler. If this is
+   * not provided, the standard logger is used.
+   */
+  Logger        Logger
+  
+}
+
+/**
+ * A configuration whose changes are audited. Every set, delete and compare-and-swap is
+ * recorded in the sink, whether or not it succeeds, along with the actor identified by
+ * the context the configuration is bound to (see WithContext).
+ * 
+ * Unlike AuditInterceptor, which only writes diagnostic messages to a logger, this
+ * produces structured records including values and is meant to be kept. The two can be
+ * combined; an audited configuration may wrap an intercepted one and vice versa, but
+ * a change refused by an interceptor is recorded with its error only if the audited
+ * configuration is outermost.
+ */
+type AuditedConfig interface {
+  Config
+  
+  /**
+   * Compare-and-swap a value, if the underlying configuration supports it.
+   */
+  CompareAndSwap(key string, value interface{}, prev int64) (interface{}, int64, error)
+  
+  /**
+   * Obtain a view of this configuration which is bound to the provided context. Changes
+   * made through it are attributed to the context's actor.
+   */
+  WithContext(ctx context.Context) AuditedConfig
+  
+}
+
+/**
+ * An audited configuration. If the underlying configuration is Observable, so is the
+ * audited configuration.
+ */
+type auditedConfig struct {
+  config    Config
+  sink      AuditSink
+  ctx       context.Context
+  options   AuditOptions
+}
+
+/**
+ * An audited configuration which can be observed
+ */
+type observableAuditedConfig struct {
+  *auditedConfig
+}
+
+/**
+ * Create an audited configuration. Changes are made even if they can't be recorded.
+ */
+func Audited(config Config, sink AuditSink) AuditedConfig {
+  return AuditedWithOptions(config, sink, nil)
+}
+
+/**
+ * Create an audited configuration with options
+ */
+func AuditedWithOptions(config Config, sink AuditSink, opts *AuditOptions) AuditedConfig {
+  c := &auditedConfig{config:config, sink:sink, ctx:context.Background()}
+  if opts != nil {
+    c.options = *opts
+  }
+  if c.options.Logger == nil {
+    c.options.Logger = defaultLogger
+  }
+  return c.audited()
+}
+
+/**
+ * Obtain the observable variant of this configuration if the underlying configuration
+ * is Observable, otherwise this configuration.
+ */
+func (c *auditedConfig) audited() AuditedConfig {
+  if _, ok := c.config.(Observable); ok {
+    return &observableAuditedConfig{c}
+  }
+  return c
+}
+
+/**
+ * Obtain a view of this configuration which is bound to the provided context. Changes
+ * made through it are attributed to the context's actor.
+ */
+func (c *auditedConfig) WithContext(ctx context.Context) AuditedConfig {
+  return (&auditedConfig{c.config, c.sink, ctx, c.options}).audited()
+}
+
+/**
+ * Obtain a configuration value.
+ */
+func (c *auditedConfig) Get(key string) (interface{}, error) {
+  return c.config.Get(key)
+}
+
+/**
+ * Set a configuration value. The canonical form of the value is returned.
+ */
+func (c *auditedConfig) Set(key string, value interface{}) (interface{}, error) {
+  var res interface{}
+  var index int64
+  var err error
+  
+  old := c.previous(key)
+  if !c.prepare(OpSet, key, old, value) {
+    return nil, AuditFailedError
+  }
+  if s, ok := c.config.(indexSetter); ok {
+    res, index, err = s.SetWithIndex(key, value)
+  }else{
+    res, err = c.config.Set(key, value)
+  }
+  
+  c.record(OpSet, key, old, value, index, err)
+  return res, err
+}
+
+/**
+ * Set a configuration value if it has not been modified since the provided index. This
+ * is only supported if the underlying configuration supports it.
+ */
+func (c *auditedConfig) CompareAndSwap(key string, value interface{}, prev int64) (interface{}, int64, error) {
+  s, ok := c.config.(compareAndSwapper)
+  if !ok {
+    return nil, -1, CompareAndSwapUnsupportedError
+  }
+  
+  old := c.previous(key)
+  if !c.prepare(OpCompareAndSwap, key, old, value) {
+    return nil, -1, AuditFailedError
+  }
+  res, index, err := s.CompareAndSwap(key, value, prev)
+  
+  c.record(OpCompareAndSwap, key, old, value, index, err)
+  return res, index, err
+}
+
+/**
+ * Delete a configuration key/value.
+ */
+func (c *auditedConfig) Delete(key string) error {
+  var index int64
+  var err error
+  
+  old := c.previous(key)
+  if !c.prepare(OpDelete, key, old, nil) {
+    return AuditFailedError
+  }
+  if d, ok := c.config.(indexDeleter); ok {
+    index, err = d.DeleteWithIndex(key)
+  }else{
+    err = c.config.Delete(key)
+  }
+  
+  c.record(OpDelete, key, old, nil, index, err)
+  return err
+}
+
+/**
+ * Watch a configuration value for changes asynchronously.
+ */
+func (c *observableAuditedConfig) Watch(key string, observer Observer) {
+  c.config.(Observable).Watch(key, observer)
+}
+
+/**
+ * Describe where a key is defined, if the underlying configuration can.
+ */
+func (c *auditedConfig) Locate(key string) (*Location, error) {
+  if l, ok := c.config.(Locator); ok {
+    return l.Locate(key)
+  }
+  return nil, nil
+}
+
+/**
+ * List keys, if the underlying configuration can.
+ */
+func (c *auditedConfig) Keys(prefix string) ([]string, error) {
+  if l, ok := c.config.(Lister); ok {
+    return l.Keys(prefix)
+  }
+  return nil, NotListableError
+}
+
+/**
+ * List values, if the underlying configuration can.
+ */
+func (c *auditedConfig) Values(prefix string) (map[string]interface{}, error) {
+  if l, ok := c.config.(ValueLister); ok {
+    return l.Values(prefix)
+  }
+  return nil, NotListableError
+}
+
+/**
+ * Obtain the value of a key before it is changed, if it has one
+ */
+func (c *auditedConfig) previous(key string) interface{} {
+  v, err := c.config.Get(key)
+  if err != nil {
+    return nil
+  }
+  return v
+}
+
+/**
+ * If we fail closed, record a change which is about to be attempted. If the change may
+ * not proceed because it couldn't be recorded, false is returned.
+ */
+func (c *auditedConfig) prepare(op, key string, old, value interface{}) bool {
+  if !c.options.FailClosed {
+    return true
+  }
+  r := c.newRecord(op, key, old, value)
+  r.Pending = true
+  return c.write(r)
+}
+
+/**
+ * Record a change. The change has already been made at this point, so if it can't be
+ * recorded the best we can do is report it.
+ */
+func (c *auditedConfig) record(op, key string, old, value interface{}, index int64, err error) {
+  r := c.newRecord(op, key, old, value)
+  if index > 0 {
+    r.Index = index
+  }
+  if err != nil {
+    r.Error = err.Error()
+  }
+  c.write(r)
+}
+
+/**
+ * Create a record of a change made by our actor
+ */
+func (c *auditedConfig) newRecord(op, key string, old, value interface{}) *AuditRecord {
+  r := &AuditRecord{Time:time.Now(), Op:op, Key:key, Old:old, New:value}
+  if a, ok := ActorFromContext(c.ctx); ok {
+    r.Actor = a
+  }
+  return r
+}
+
+/**
+ * Write a record to the sink, reporting it if that fails
+ */
+func (c *auditedConfig) write(r *AuditRecord) bool {
+  err := c.sink.Record(r)
+  if err == nil {
+    return true
+  }
+  if c.options.OnError != nil {
+    c.options.OnError(r, err)
+  }else{
+    c.options.Logger.Logf(LevelError, "[%s] Could not record %s by %q: %v", r.Key, r.Op, r.Actor, err)
+  }
+  return false
+}
+
@@ -0,0 +1,210 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "bytes"
+  "errors"
+  "strings"
+  "context"
+  "testing"
+  "encoding/json"
+)
+
+func TestAudit(t *testing.T) {
+  
+  buf := &bytes.Buffer{}
+  m := NewMemoryConfig(map[string]interface{}{"db.host": "old.local"})
+  a := Audited(m, NewJSONAuditSink(buf))
+  
+  c := a.WithContext(WithActor(context.Background(), "alice"))
+  if _, err := c.Set("db.host", "new.local"); err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if err := c.Delete("db.host"); err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }
+  if _, _, err := c.CompareAndSwap("db.host", "cas.local", -1); err != nil {
+    t.Errorf("Could not compare-and-swap: %v", err)
+  }
+  if _, _, err := Audited(ReadOnly(m), NewJSONAuditSink(buf)).CompareAndSwap("db.host", "cas.local", 1); err != CompareAndSwapUnsupportedError {
+    t.Errorf("Expected compare-and-swap to be unsupported: %v", err)
+  }
+  if _, err := ReadOnly(m).Set("db.host", "Nope"); err != ReadOnlyError {
+    t.Errorf("Expected a read-only error: %v", err)
+  }
+  if _, err := Audited(ReadOnly(m), NewJSONAuditSink(buf)).Set("db.port", 5432); err != ReadOnlyError {
+    t.Errorf("Expected a read-only error: %v", err)
+  }
+  
+  lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+  if len(lines) != 4 {
+    t.Errorf("Unexpected records: %v", lines)
+    return
+  }
+  
+  records := make([]*AuditRecord, len(lines))
+  for i, l := range lines {
+    records[i] = &AuditRecord{}
+    if err := json.Unmarshal([]byte(l), records[i]); err != nil {
+      t.Errorf("Could not decode record: %v", err)
+      return
+    }
+  }
+  if r := records[0]; r.Actor != "alice" || r.Op != OpSet || r.Key != "db.host" || r.Old != "old.local" || r.New != "new.local" || r.Index != 2 || r.Time.IsZero() {
+    t.Errorf("Unexpected record: %+v", r)
+  }
+  if r := records[1]; r.Actor != "alice" || r.Op != OpDelete || r.Old != "new.local" || r.New != nil || r.Index != 3 {
+    t.Errorf("Unexpected record: %+v", r)
+  }
+  if r := records[2]; r.Actor != "alice" || r.Op != OpCompareAndSwap || r.Old != nil || r.New != "cas.local" || r.Index != 4 {
+    t.Errorf("Unexpected record: %+v", r)
+  }
+  if r := records[3]; r.Actor != "" || r.Op != OpSet || r.Error != ReadOnlyError.Error() {
+    t.Errorf("Unexpected record: %+v", r)
+  }
+  
+}
+
+func TestAuditConfigSink(t *testing.T) {
+  
+  log := NewMemoryConfig(nil)
+  a := Audited(NewMemoryConfig(nil), NewConfigAuditSink(log, "audit"))
+  
+  for _, v := range []string{"A", "B", "C"} {
+    if _, err := a.Set("key", v); err != nil {
+      t.Errorf("Could not set: %v", err)
+    }
+  }
+  
+  keys, err := log.Keys("audit")
+  if err != nil {
+    t.Errorf("Could not list: %v", err)
+  }else if len(keys) != 3 {
+    t.Errorf("Unexpected records: %v", keys)
+  }
+  
+}
+
+
+type failingAuditSink struct {
+  records []*AuditRecord
+  fail    bool
+}
+
+func (s *failingAuditSink) Record(r *AuditRecord) error {
+  if s.fail {
+    return errors.New("Sink is unavailable")
+  }
+  s.records = append(s.records, r)
+  return nil
+}
+
+func TestAuditSinkFailure(t *testing.T) {
+  
+  var failed []*AuditRecord
+  onError := func(r *AuditRecord, err error) {
+    failed = append(failed, r)
+  }
+  
+  m := NewMemoryConfig(map[string]interface{}{"db.host": "old.local"})
+  sink := &failingAuditSink{fail:true}
+  
+  // failing open, the change is made anyway
+  a := AuditedWithOptions(m, sink, &AuditOptions{OnError:onError})
+  if _, err := a.Set("db.host", "open.local"); err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v, _ := m.Get("db.host"); v != "open.local" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if len(failed) != 1 || failed[0].Op != OpSet || failed[0].Pending {
+    t.Errorf("Unexpected failures: %v", failed)
+  }
+  
+  // failing closed, the change is refused
+  failed = nil
+  a = AuditedWithOptions(m, sink, &AuditOptions{FailClosed:true, OnError:onError})
+  if _, err := a.Set("db.host", "closed.local"); err != AuditFailedError {
+    t.Errorf("Expected the change to be refused: %v", err)
+  }
+  if err := a.Delete("db.host"); err != AuditFailedError {
+    t.Errorf("Expected the change to be refused: %v", err)
+  }
+  if v, _ := m.Get("db.host"); v != "open.local" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if len(failed) != 2 || !failed[0].Pending {
+    t.Errorf("Unexpected failures: %v", failed)
+  }
+  
+  // once the sink recovers, changes are recorded before and after they are made
+  sink.fail = false
+  if _, err := a.Set("db.host", "closed.local"); err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if len(sink.records) != 2 || !sink.records[0].Pending || sink.records[1].Pending || sink.records[1].Index == 0 {
+    t.Errorf("Unexpected records: %v", sink.records)
+  }
+  
+}
+func TestAuditPassthrough(t *testing.T) {
+  buf := &bytes.Buffer{}
+  m := NewMemoryConfig(map[string]interface{}{"db.host": "db.local", "db.port": 5432})
+  
+  a := Audited(m, NewJSONAuditSink(buf))
+  if _, ok := a.(Observable); !ok {
+    t.Errorf("Expected an audited memory config to be observable")
+  }
+  if _, ok := a.WithContext(context.Background()).(Observable); !ok {
+    t.Errorf("Expected a view of an audited memory config to be observable")
+  }
+  if _, ok := Audited(struct{ Config }{m}, NewJSONAuditSink(buf)).(Observable); ok {
+    t.Errorf("Expected an audited config which can't be observed not to be observable")
+  }
+  
+  if k, err := a.(Lister).Keys("db"); err != nil || len(k) != 2 {
+    t.Errorf("Unexpected keys: %v, %v", k, err)
+  }
+  if v, err := a.(ValueLister).Values("db"); err != nil || v["db.host"] != "db.local" {
+    t.Errorf("Unexpected values: %v, %v", v, err)
+  }
+  if _, err := Audited(struct{ Config }{m}, NewJSONAuditSink(buf)).(Lister).Keys("db"); err != NotListableError {
+    t.Errorf("Expected keys not to be listable: %v", err)
+  }
+  
+  l := testLocator{m, "DB_HOST"}
+  if v, err := Audited(l, NewJSONAuditSink(buf)).(Locator).Locate("db.host"); err != nil || v.Variable != "DB_HOST" {
+    t.Errorf("Unexpected location: %v, %v", v, err)
+  }
+  
+}
+
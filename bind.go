@@ -0,0 +1,404 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "errors"
+  "reflect"
+  "strings"
+  "sync/atomic"
+)
+
+var InvalidBindingError = errors.New("Binding target must be a pointer to a struct")
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+
+/**
+ * Implemented by bound structs which can validate themselves. A struct which fails
+ * validation is not used.
+ */
+type Validator interface {
+  Validate() error
+}
+
+/**
+ * An error binding a particular key
+ */
+type BindingError struct {
+  Key   string
+  Err   error
+}
+
+/**
+ * Error
+ */
+func (e BindingError) Error() string {
+  return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+/**
+ * Unwrap
+ */
+func (e BindingError) Unwrap() error {
+  return e.Err
+}
+
+/**
+ * Bind the configuration under a prefix to the fields of a struct. Each exported field
+ * is bound to the key formed by appending its name to the prefix; nested structs are
+ * bound to the keys under their own name. Structs which have no fields that can be bound
+ * (e.g., time.Time, which is parsed as RFC 3339) are bound to a single key like any other
+ * value. The name of a field can be provided with a
+ * `conf` tag (e.g., `conf:"pool_size"`), or a field can be skipped with `conf:"-"`. A
+ * field which is tagged as required (e.g., `conf:"host,required"`) must be present in
+ * the configuration; other fields retain their existing value when they are absent.
+ * 
+ * If the struct implements Validator, it is validated once it has been bound.
+ */
+func Bind(config Config, prefix string, v interface{}) error {
+  z := reflect.ValueOf(v)
+  if z.Kind() != reflect.Ptr || z.IsNil() || z.Elem().Kind() != reflect.Struct {
+    return InvalidBindingError
+  }
+  err := bindStruct(config, prefix, z.Elem())
+  if err != nil {
+    return err
+  }
+  if c, ok := v.(Validator); ok {
+    return c.Validate()
+  }
+  return nil
+}
+
+/**
+ * A bound field
+ */
+type bindingField struct {
+  index       int
+  key         string
+  required    bool
+}
+
+/**
+ * Determine the fields of a struct which are bound and the keys they are bound to
+ */
+func bindingFields(prefix string, t reflect.Type) []bindingField {
+  fields := make([]bindingField, 0, t.NumField())
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" {
+      continue // unexported
+    }
+    
+    name := strings.ToLower(f.Name[:1]) + f.Name[1:]
+    var required bool
+    if tag := f.Tag.Get("conf"); tag != "" {
+      parts := strings.Split(tag, ",")
+      if parts[0] == "-" {
+        continue
+      }else if parts[0] != "" {
+        name = parts[0]
+      }
+      for _, e := range parts[1:] {
+        if e == "required" {
+          required = true
+        }
+      }
+    }
+    
+    key := name
+    if prefix != "" {
+      key = prefix +"."+ name
+    }
+    
+    fields = append(fields, bindingField{i, key, required})
+  }
+  return fields
+}
+
+/**
+ * Determine if a type is a struct whose fields are bound individually
+ */
+func isNested(t reflect.Type) bool {
+  return t.Kind() == reflect.Struct && len(bindingFields("", t)) > 0
+}
+
+/**
+ * Determine every key which is bound for a struct
+ */
+func bindingKeys(prefix string, t reflect.Type) []string {
+  keys := make([]string, 0)
+  for _, f := range bindingFields(prefix, t) {
+    if ft := t.Field(f.index).Type; isNested(ft) {
+      keys = append(keys, bindingKeys(f.key, ft)...)
+    }else{
+      keys = append(keys, f.key)
+    }
+  }
+  return keys
+}
+
+/**
+ * Bind the fields of a struct
+ */
+func bindStruct(config Config, prefix string, z reflect.Value) error {
+  for _, f := range bindingFields(prefix, z.Type()) {
+    field := z.Field(f.index)
+    
+    if isNested(field.Type()) {
+      err := bindStruct(config, f.key, field)
+      if err != nil {
+        return err
+      }
+      continue
+    }
+    
+    v, err := config.Get(f.key)
+    if err == NoSuchKeyError {
+      if f.required {
+        return BindingError{f.key, err}
+      }
+      continue
+    }else if err != nil {
+      return BindingError{f.key, err}
+    }
+    
+    err = assignValue(field, v)
+    if err != nil {
+      return BindingError{f.key, err}
+    }
+  }
+  return nil
+}
+
+/**
+ * Assign a configuration value to a field, converting it as necessary
+ */
+func assignValue(field reflect.Value, v interface{}) error {
+  
+  if field.Type() == durationType {
+    d, err := AsDuration(v)
+    if err != nil {
+      return err
+    }
+    field.SetInt(int64(d))
+    return nil
+  }
+  
+  if s, ok := v.(string); ok && field.Type() == timeType {
+    t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+    if err != nil {
+      return fmt.Errorf("Cannot parse %q as time", s)
+    }
+    field.Set(reflect.ValueOf(t))
+    return nil
+  }
+  
+  switch field.Kind() {
+    
+    case reflect.String:
+      s, err := AsString(v)
+      if err != nil {
+        return err
+      }
+      field.SetString(s)
+      
+    case reflect.Bool:
+      b, err := AsBool(v)
+      if err != nil {
+        return err
+      }
+      field.SetBool(b)
+      
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      n, err := AsInt(v)
+      if err != nil {
+        return err
+      }
+      if field.OverflowInt(n) {
+        return fmt.Errorf("Value %v overflows %v", n, field.Type())
+      }
+      field.SetInt(n)
+      
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+      n, err := AsInt(v)
+      if err != nil {
+        return err
+      }
+      if n < 0 || field.OverflowUint(uint64(n)) {
+        return fmt.Errorf("Value %v overflows %v", n, field.Type())
+      }
+      field.SetUint(uint64(n))
+      
+    case reflect.Float32, reflect.Float64:
+      n, err := AsFloat(v)
+      if err != nil {
+        return err
+      }
+      field.SetFloat(n)
+      
+    case reflect.Slice:
+      z := reflect.ValueOf(v)
+      if z.Kind() != reflect.Slice {
+        return fmt.Errorf("Cannot assign (%T) %v to %v", v, v, field.Type())
+      }
+      s := reflect.MakeSlice(field.Type(), z.Len(), z.Len())
+      for i := 0; i < z.Len(); i++ {
+        err := assignValue(s.Index(i), z.Index(i).Interface())
+        if err != nil {
+          return err
+        }
+      }
+      field.Set(s)
+      
+    default:
+      z := reflect.ValueOf(v)
+      if !z.IsValid() || !z.Type().AssignableTo(field.Type()) {
+        return fmt.Errorf("Cannot assign (%T) %v to %v", v, v, field.Type())
+      }
+      field.Set(z)
+      
+  }
+  
+  return nil
+}
+
+/**
+ * A struct binding which is kept current as the configuration changes. Each time a bound
+ * key changes, a new struct is bound and validated; if that succeeds it replaces the
+ * previous one, otherwise the previous struct remains in place and the error is reported.
+ */
+type LiveBinding struct {
+  state     sync.Mutex
+  config    Config
+  prefix    string
+  defaults  reflect.Value
+  current   atomic.Value
+  err       error
+  onError   []func(error)
+}
+
+/**
+ * Create a live binding for the configuration under a prefix. The provided struct, which
+ * must be a pointer, is not modified; it provides the default values of the fields which
+ * are absent from the configuration. See Bind for how fields are bound.
+ * 
+ * If the configuration is Observable, the bound keys are watched and the binding is
+ * updated as they change. Otherwise, it can be updated explicitly by calling Reload.
+ */
+func Live(config Config, prefix string, v interface{}) (*LiveBinding, error) {
+  z := reflect.ValueOf(v)
+  if z.Kind() != reflect.Ptr || z.IsNil() || z.Elem().Kind() != reflect.Struct {
+    return nil, InvalidBindingError
+  }
+  
+  // watch the keys before they are first read so that a change made in between isn't
+  // missed; reloads are serialized, so the last one reads the latest values
+  b := &LiveBinding{config:config, prefix:prefix, defaults:z.Elem()}
+  if o, ok := config.(Observable); ok {
+    for _, k := range bindingKeys(prefix, z.Elem().Type()) {
+      o.Watch(k, func(string, interface{}) {
+        b.Reload()
+      })
+    }
+  }
+  
+  err := b.Reload()
+  if err != nil {
+    return nil, err
+  }
+  
+  return b, nil
+}
+
+/**
+ * Obtain the current struct. This is a pointer to the same type that the binding was
+ * created with. It must be treated as immutable, since it is shared by everyone who loads
+ * it; when the configuration changes, a new struct is provided rather than this one being
+ * modified.
+ */
+func (b *LiveBinding) Load() interface{} {
+  return b.current.Load()
+}
+
+/**
+ * Obtain the error from the most recent update, if it failed
+ */
+func (b *LiveBinding) Err() error {
+  b.state.Lock()
+  defer b.state.Unlock()
+  return b.err
+}
+
+/**
+ * Add a function which is called when an update fails
+ */
+func (b *LiveBinding) OnError(f func(error)) {
+  b.state.Lock()
+  defer b.state.Unlock()
+  b.onError = append(b.onError, f)
+}
+
+/**
+ * Bind a new struct from the current configuration and, if it's valid, make it the
+ * current struct.
+ */
+func (b *LiveBinding) Reload() error {
+  b.state.Lock()
+  
+  z := reflect.New(b.defaults.Type())
+  z.Elem().Set(b.defaults)
+  
+  err := Bind(b.config, b.prefix, z.Interface())
+  if err == nil {
+    b.current.Store(z.Interface())
+  }
+  b.err = err
+  
+  var observers []func(error)
+  if err != nil && len(b.onError) > 0 {
+    observers = make([]func(error), len(b.onError))
+    copy(observers, b.onError)
+  }
+  
+  b.state.Unlock()
+  
+  for _, f := range observers {
+    f(err)
+  }
+  
+  return err
+}
+
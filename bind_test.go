@@ -0,0 +1,187 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "sync"
+  "time"
+  "errors"
+  "reflect"
+  "testing"
+)
+
+type testPool struct {
+  Size      int
+  Timeout   time.Duration
+}
+
+type testBinding struct {
+  Host      string        `conf:"host,required"`
+  Port      uint16
+  Debug     bool
+  Ratio     float64
+  Tags      []string
+  Pool      testPool
+  Started   time.Time
+  Ignored   string        `conf:"-"`
+}
+
+func (b *testBinding) Validate() error {
+  if b.Pool.Size < 1 {
+    return errors.New("Pool size must be positive")
+  }
+  return nil
+}
+
+func TestBind(t *testing.T) {
+  
+  m := NewMemoryConfig(map[string]interface{}{
+    "svc.host": "localhost",
+    "svc.port": "8080",
+    "svc.debug": "true",
+    "svc.ratio": 0.5,
+    "svc.tags": []interface{}{"a", "b"},
+    "svc.pool.timeout": "1m",
+    "svc.started": "2015-06-01T12:00:00Z",
+    "svc.ignored": "Nope",
+  })
+  
+  b := &testBinding{Pool:testPool{Size:4}}
+  err := Bind(m, "svc", b)
+  if err != nil {
+    t.Errorf("Could not bind: %v", err)
+  }
+  if b.Host != "localhost" || b.Port != 8080 || !b.Debug || b.Ratio != 0.5 || len(b.Tags) != 2 || b.Ignored != "" {
+    t.Errorf("Unexpected binding: %+v", b)
+  }
+  if !b.Started.Equal(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)) {
+    t.Errorf("Unexpected time: %v", b.Started)
+  }
+  if k := bindingKeys("svc", reflect.TypeOf(testBinding{})); len(k) != 8 || k[7] != "svc.started" {
+    t.Errorf("Unexpected keys: %v", k)
+  }
+  if b.Pool.Size != 4 || b.Pool.Timeout != time.Minute {
+    t.Errorf("Unexpected binding: %+v", b.Pool)
+  }
+  
+  err = Bind(m, "svc", *b)
+  if err != InvalidBindingError {
+    t.Errorf("Expected an invalid binding: %v", err)
+  }
+  
+  m.Set("svc.port", "100000")
+  err = Bind(m, "svc", &testBinding{Pool:testPool{Size:4}})
+  if e, ok := err.(BindingError); !ok || e.Key != "svc.port" {
+    t.Errorf("Expected a binding error: %v", err)
+  }
+  
+  m.Delete("svc.host")
+  err = Bind(m, "svc", &testBinding{Pool:testPool{Size:4}})
+  if e, ok := err.(BindingError); !ok || e.Key != "svc.host" || e.Err != NoSuchKeyError {
+    t.Errorf("Expected a binding error: %v", err)
+  }
+  
+}
+
+func TestLive(t *testing.T) {
+  
+  m := NewMemoryConfig(map[string]interface{}{
+    "svc.host": "localhost",
+    "svc.pool.size": 2,
+  })
+  
+  l, err := Live(m, "svc", &testBinding{Port:80})
+  if err != nil {
+    t.Errorf("Could not bind: %v", err)
+    return
+  }
+  
+  e := make(chan error, 1)
+  l.OnError(func(err error) {
+    e <- err
+  })
+  
+  b := l.Load().(*testBinding)
+  if b.Host != "localhost" || b.Port != 80 || b.Pool.Size != 2 {
+    t.Errorf("Unexpected binding: %+v", b)
+  }
+  
+  m.Set("svc.pool.size", 8)
+  deadline := time.Now().Add(time.Second)
+  for l.Load().(*testBinding).Pool.Size != 8 && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+  }
+  if c := l.Load().(*testBinding); c.Pool.Size != 8 {
+    t.Errorf("Binding was not updated: %+v", c)
+  }else if b.Pool.Size != 2 {
+    t.Errorf("Previous binding was modified: %+v", b)
+  }
+  
+  // an invalid update leaves the previous binding in place
+  m.Set("svc.pool.size", 0)
+  err = <- e
+  if err == nil || l.Err() == nil {
+    t.Errorf("Expected an error")
+  }
+  if c := l.Load().(*testBinding); c.Pool.Size != 8 {
+    t.Errorf("Binding should not have been updated: %+v", c)
+  }
+  
+  _, err = Live(m, "svc", &testBinding{})
+  if err == nil {
+    t.Errorf("Expected an error")
+  }
+  
+  // a change made just after the binding is first read is not missed
+  var once sync.Once
+  m = NewMemoryConfig(map[string]interface{}{"svc.host": "localhost", "svc.pool.size": 2})
+  c := Wrap(m, func(op *Operation, next Invoker) (interface{}, error) {
+    v, err := next(op)
+    if op.Op == OpGet && op.Key == "svc.host" {
+      once.Do(func() { m.Set("svc.host", "changed.local") })
+    }
+    return v, err
+  })
+  l, err = Live(c, "svc", &testBinding{})
+  if err != nil {
+    t.Errorf("Could not bind: %v", err)
+    return
+  }
+  deadline = time.Now().Add(time.Second)
+  for l.Load().(*testBinding).Host != "changed.local" && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+  }
+  if c := l.Load().(*testBinding); c.Host != "changed.local" {
+    t.Errorf("Binding was not updated: %+v", c)
+  }
+  
+}
+
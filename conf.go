@@ -39,6 +39,42 @@ var KeyCollisionError = errors.New("Key already exists")
 var TimeoutError      = errors.New("Request timed out")
 var ClientError       = errors.New("Client error")
 var ServiceError      = errors.New("Service error")
+var InvalidTTLError   = errors.New("Invalid TTL")
+var ReadOnlyError     = errors.New("Configuration is read-only")
+
+/**
+ * Event actions
+ */
+const (
+  ActionSet               = "set"
+  ActionUpdate            = "update"
+  ActionCreate            = "create"
+  ActionDelete            = "delete"
+  ActionExpire            = "expire"
+  ActionCompareAndSwap    = "compareAndSwap"
+  ActionCompareAndDelete  = "compareAndDelete"
+  ActionResync            = "resync" // a watch fell behind and the current state was read again
+)
+
+/**
+ * A configuration change event
+ */
+type Event struct {
+  Action      string
+  Key         string
+  Value       interface{}
+  Index       int64
+}
+
+/**
+ * Key observer
+ */
+type Observer func(string, interface{})
+
+/**
+ * Key event observer
+ */
+type EventObserver func(*Event)
 
 /**
  * A configuration
@@ -62,3 +98,14 @@ type Config interface {
   
 }
 
+/**
+ * An observable configuration, which notifies observers when values change
+ */
+type Observable interface {
+  
+  /**
+   * Watch a configuration value for changes asynchronously.
+   */
+  Watch(key string, observer Observer)
+  
+}
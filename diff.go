@@ -0,0 +1,253 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "fmt"
+  "sort"
+  "errors"
+  "reflect"
+  "strings"
+)
+
+var NotListableError = errors.New("Configuration cannot be listed")
+
+/**
+ * Implemented by configurations which can list their keys
+ */
+type Lister interface {
+  
+  /**
+   * List the keys which are equal to or under the provided prefix. If the prefix is
+   * empty, every key is listed.
+   */
+  Keys(prefix string) ([]string, error)
+  
+}
+
+/**
+ * Implemented by configurations which can list their keys along with their values more
+ * efficiently than by getting each key in turn.
+ */
+type ValueLister interface {
+  
+  /**
+   * Obtain the values of the keys which are equal to or under the provided prefix. If
+   * the prefix is empty, every value is listed.
+   */
+  Values(prefix string) (map[string]interface{}, error)
+  
+}
+
+/**
+ * Kinds of change
+ */
+const (
+  ChangeAdded   = "added"
+  ChangeRemoved = "removed"
+  ChangeChanged = "changed"
+)
+
+/**
+ * A change to a key
+ */
+type Change struct {
+  Kind      string
+  Key       string
+  Old       interface{}
+  New       interface{}
+}
+
+/**
+ * The differences between two configurations
+ */
+type Difference struct {
+  Added     []*Change
+  Removed   []*Change
+  Changed   []*Change
+}
+
+/**
+ * Determine if there are no differences
+ */
+func (d *Difference) Empty() bool {
+  return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+/**
+ * Obtain every change, ordered by key
+ */
+func (d *Difference) Changes() []*Change {
+  c := make([]*Change, 0, len(d.Added) + len(d.Removed) + len(d.Changed))
+  c = append(c, d.Added...)
+  c = append(c, d.Removed...)
+  c = append(c, d.Changed...)
+  sort.Sort(changesByKey(c))
+  return c
+}
+
+/**
+ * Describe the differences, one change per line, in the style of a diff: added keys are
+ * prefixed by '+', removed keys by '-' and changed keys by '~'.
+ */
+func (d *Difference) String() string {
+  b := &strings.Builder{}
+  for _, c := range d.Changes() {
+    switch c.Kind {
+      case ChangeAdded:
+        fmt.Fprintf(b, "+ %s: %v\n", c.Key, c.New)
+      case ChangeRemoved:
+        fmt.Fprintf(b, "- %s: %v\n", c.Key, c.Old)
+      case ChangeChanged:
+        fmt.Fprintf(b, "~ %s: %v -> %v\n", c.Key, c.Old, c.New)
+    }
+  }
+  return b.String()
+}
+
+/**
+ * Compare the keys under a prefix in two configurations. Keys which are only in b are
+ * added, keys which are only in a are removed, and keys whose values differ are changed.
+ * Both configurations must implement Lister. Configurations which implement ValueLister
+ * are read all at once; otherwise each key is read in turn.
+ * 
+ * Values are compared by their string form when their types differ, since backends
+ * don't all represent values the same way (e.g., etcd only stores strings).
+ */
+func Diff(a, b Config, prefix string) (*Difference, error) {
+  
+  avals, err := listValues(a, prefix)
+  if err != nil {
+    return nil, err
+  }
+  bvals, err := listValues(b, prefix)
+  if err != nil {
+    return nil, err
+  }
+  
+  d := &Difference{}
+  for _, k := range valueKeys(avals) {
+    av := avals[k]
+    if bv, ok := bvals[k]; !ok {
+      d.Removed = append(d.Removed, &Change{Kind:ChangeRemoved, Key:k, Old:av})
+    }else if !sameValue(av, bv) {
+      d.Changed = append(d.Changed, &Change{Kind:ChangeChanged, Key:k, Old:av, New:bv})
+    }
+  }
+  for _, k := range valueKeys(bvals) {
+    if _, ok := avals[k]; !ok {
+      d.Added = append(d.Added, &Change{Kind:ChangeAdded, Key:k, New:bvals[k]})
+    }
+  }
+  
+  return d, nil
+}
+
+/**
+ * Obtain the values of the keys under a prefix in a configuration
+ */
+func listValues(c Config, prefix string) (map[string]interface{}, error) {
+  if l, ok := c.(ValueLister); ok {
+    vals, err := l.Values(prefix)
+    if err != NotListableError {
+      return vals, err
+    }
+  }
+  l, ok := c.(Lister)
+  if !ok {
+    return nil, NotListableError
+  }
+  keys, err := l.Keys(prefix)
+  if err != nil {
+    return nil, err
+  }
+  return getValues(c, keys)
+}
+
+/**
+ * Obtain the keys of a set of values, in order
+ */
+func valueKeys(vals map[string]interface{}) []string {
+  keys := make([]string, 0, len(vals))
+  for k := range vals {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+/**
+ * Obtain the values of keys one at a time. Keys which no longer exist are omitted.
+ */
+func getValues(c Config, keys []string) (map[string]interface{}, error) {
+  vals := make(map[string]interface{})
+  for _, k := range keys {
+    v, err := c.Get(k)
+    if err == NoSuchKeyError {
+      continue
+    }else if err != nil {
+      return nil, err
+    }
+    vals[k] = v
+  }
+  return vals, nil
+}
+
+/**
+ * Determine if two values are the same
+ */
+func sameValue(a, b interface{}) bool {
+  if reflect.DeepEqual(a, b) {
+    return true
+  }
+  if reflect.TypeOf(a) == reflect.TypeOf(b) {
+    return false
+  }
+  as, err := AsString(a)
+  if err != nil {
+    return false
+  }
+  bs, err := AsString(b)
+  if err != nil {
+    return false
+  }
+  return as == bs
+}
+
+/**
+ * Sort changes by key
+ */
+type changesByKey []*Change
+
+func (v changesByKey) Len() int            { return len(v) }
+func (v changesByKey) Swap(a, b int)       { v[a], v[b] = v[b], v[a] }
+func (v changesByKey) Less(a, b int) bool  { return v[a].Key < v[b].Key }
+
@@ -0,0 +1,94 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "testing"
+)
+
+func TestDiff(t *testing.T) {
+  
+  staging := NewMemoryConfig(map[string]interface{}{
+    "svc.host": "staging.local",
+    "svc.port": 8080,
+    "svc.debug": true,
+    "svc.retries": 3,
+    "other.key": "Ignored",
+  })
+  prod := NewMemoryConfig(map[string]interface{}{
+    "svc.host": "prod.local",
+    "svc.port": "8080",
+    "svc.timeout": "5s",
+    "svc.retries": 3,
+  })
+  
+  d, err := Diff(staging, ReadOnly(prod), "svc")
+  if err != nil {
+    t.Errorf("Could not diff: %v", err)
+    return
+  }
+  if len(d.Added) != 1 || d.Added[0].Key != "svc.timeout" || d.Added[0].New != "5s" {
+    t.Errorf("Unexpected additions: %v", d.Added)
+  }
+  if len(d.Removed) != 1 || d.Removed[0].Key != "svc.debug" || d.Removed[0].Old != true {
+    t.Errorf("Unexpected removals: %v", d.Removed)
+  }
+  if len(d.Changed) != 1 || d.Changed[0].Key != "svc.host" || d.Changed[0].Old != "staging.local" || d.Changed[0].New != "prod.local" {
+    t.Errorf("Unexpected changes: %v", d.Changed)
+  }
+  
+  expect := "- svc.debug: true\n~ svc.host: staging.local -> prod.local\n+ svc.timeout: 5s\n"
+  if s := d.String(); s != expect {
+    t.Errorf("Unexpected description: %q", s)
+  }
+  
+  d, err = Diff(staging, staging, "")
+  if err != nil {
+    t.Errorf("Could not diff: %v", err)
+  }else if !d.Empty() {
+    t.Errorf("Expected no differences: %v", d)
+  }
+  
+  // intercepted configurations can't list values, so each key is read in turn
+  d, err = Diff(Wrap(staging), prod, "svc")
+  if err != nil {
+    t.Errorf("Could not diff: %v", err)
+  }else if s := d.String(); s != expect {
+    t.Errorf("Unexpected description: %q", s)
+  }
+  
+  _, err = Diff(staging, Wrap(NewConfigSuiteWithLayers()), "")
+  if err != NotListableError {
+    t.Errorf("Expected a configuration which can't be listed: %v", err)
+  }
+  
+}
+
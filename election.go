@@ -0,0 +1,229 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "sync"
+  "time"
+  "errors"
+  "context"
+)
+
+var CampaignInProgressError = errors.New("Already campaigning")
+
+/**
+ * A leader election backed by etcd. Candidates campaign for leadership by attempting to
+ * hold the election key; the leader's value is stored in that key for as long as it
+ * remains leader. If the leader goes away its key expires after the TTL and another
+ * candidate is elected.
+ */
+type Election struct {
+  state       sync.Mutex
+  config      *EtcdConfig
+  key         string
+  ttl         time.Duration
+  mutex       *Mutex
+  campaigning bool
+}
+
+/**
+ * Create an election which is held by way of the provided key. The TTL determines how
+ * long leadership survives if the leader stops refreshing it; it must be at least one
+ * second.
+ */
+func NewElection(config *EtcdConfig, key string, ttl time.Duration) *Election {
+  return &Election{config:config, key:key, ttl:ttl}
+}
+
+/**
+ * Campaign for leadership. This method blocks until we are elected or the context is
+ * canceled. The value identifies this candidate to observers of the election and must
+ * be unique among candidates. If we are already the leader, LockHeldError is returned;
+ * if we are already campaigning, CampaignInProgressError is returned.
+ */
+func (e *Election) Campaign(ctx context.Context, value string) error {
+  
+  // reserve our candidacy; if we were previously elected and lost our leadership, we
+  // clean up and campaign again
+  e.state.Lock()
+  if e.campaigning {
+    e.state.Unlock()
+    return CampaignInProgressError
+  }
+  prev := e.mutex
+  if prev != nil {
+    select {
+      case <- prev.Lost():
+        e.mutex = nil
+      default:
+        e.state.Unlock()
+        return LockHeldError
+    }
+  }
+  e.campaigning = true
+  e.state.Unlock()
+  
+  if prev != nil {
+    prev.Unlock(ctx) // this is expected to fail; we don't hold it anymore
+  }
+  
+  m := newMutex(e.config, e.key, value, e.ttl)
+  err := m.Lock(ctx)
+  
+  e.state.Lock()
+  defer e.state.Unlock()
+  e.campaigning = false
+  if err != nil {
+    return err
+  }
+  e.mutex = m
+  return nil
+}
+
+/**
+ * Give up leadership. If we are not the leader, LockNotHeldError is returned.
+ */
+func (e *Election) Resign(ctx context.Context) error {
+  e.state.Lock()
+  m := e.mutex
+  e.mutex = nil
+  e.state.Unlock()
+  if m == nil {
+    return LockNotHeldError
+  }
+  return m.Unlock(ctx)
+}
+
+/**
+ * Obtain a channel which is closed if we lose leadership while we hold it (e.g., because
+ * our session with etcd was interrupted for longer than the TTL). Leadership is assumed
+ * lost once it could not be refreshed for a full TTL, even if we can't reach the cluster
+ * to find out, so a leader which is partitioned from the cluster stops acting as leader
+ * no later than another candidate can be elected. If we are not the leader, nil is
+ * returned.
+ */
+func (e *Election) Lost() <-chan struct{} {
+  e.state.Lock()
+  defer e.state.Unlock()
+  if e.mutex == nil {
+    return nil
+  }
+  return e.mutex.Lost()
+}
+
+/**
+ * Obtain the value of the current leader. If there is no leader, NoSuchKeyError is
+ * returned.
+ */
+func (e *Election) Leader() (string, error) {
+  rsp, err := e.config.get(context.Background(), e.key, false, false, 0, 0)
+  if err != nil {
+    return "", err
+  }else if rsp.Node == nil {
+    return "", NoSuchKeyError
+  }
+  return rsp.Node.Encoded, nil
+}
+
+/**
+ * Observe changes in leadership. The value of the new leader is delivered on the returned
+ * channel each time leadership changes, beginning with the current leader. An empty value
+ * means there is currently no leader. The channel is closed when the context is canceled.
+ */
+func (e *Election) Observe(ctx context.Context) <-chan string {
+  leaders := make(chan string)
+  go e.observe(ctx, leaders)
+  return leaders
+}
+
+/**
+ * Observe changes in leadership
+ */
+func (e *Election) observe(ctx context.Context, leaders chan<- string) {
+  defer close(leaders)
+  
+  var last string
+  var first = true
+  for {
+    var leader string
+    var index int64
+    var known bool
+    
+    rsp, err := e.config.get(ctx, e.key, false, false, 0, 0)
+    if err == nil && rsp.Node != nil {
+      leader, index, known = rsp.Node.Encoded, rsp.Index, true
+    }else if err == NoSuchKeyError && rsp != nil {
+      index, known = rsp.Index, true
+    }else if err != nil && err != NoSuchKeyError {
+      if ctx.Err() != nil {
+        return
+      }
+      e.config.logger.Logf(LevelWarn, "[%s] Could not observe election (backing off): %v", e.key, err)
+      select {
+        case <- time.After(time.Second):
+          continue
+        case <- ctx.Done():
+          return
+      }
+    }
+    
+    if first || leader != last {
+      select {
+        case leaders <- leader:
+          first, last = false, leader
+        case <- ctx.Done():
+          return
+      }
+    }
+    
+    // wait for the election key to change after the state we just read; if we somehow
+    // don't know the index we just wait for the next change and rely on the long-poll
+    // timeout to catch anything we may have missed in between
+    if known {
+      index++
+    }else{
+      index = 0
+    }
+    _, err = e.config.get(ctx, e.key, true, false, index, 0)
+    if ctx.Err() != nil {
+      return
+    }else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF && err != TimeoutError && err != EventIndexClearedError {
+      e.config.logger.Logf(LevelWarn, "[%s] Could not observe election (backing off): %v", e.key, err)
+      select {
+        case <- time.After(time.Second):
+        case <- ctx.Done():
+          return
+      }
+    }
+    
+  }
+}
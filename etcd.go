@@ -31,20 +31,24 @@
 package conf
 
 import (
+  "io"
   "fmt"
-  "log"
   "time"
   "strings"
+  "strconv"
+  "context"
   "net/url"
   "net/http"
-  "io/ioutil"
   "encoding/json"
 )
 
 const CONTENT_TYPE_FORM_ENCODED = "application/x-www-form-urlencoded"
 
+const etcdDefaultTimeout = time.Second * 10
+
 var InvalidIndexError     = fmt.Errorf("Invalid index")
 var ComparisonFailedError = fmt.Errorf("Comparison failed")
+var EventIndexClearedError = fmt.Errorf("Event index cleared")
 
 var httpClient = &http.Client{Transport:http.DefaultTransport}
 
@@ -74,6 +78,7 @@ type etcdResponse struct {
   Action      string            `json:"action"`
   Node        *etcdNode         `json:"node"`
   Previous    *etcdNode         `json:"prevNode"`
+  Index       int64             `json:"-"` // the etcd index at the time of the response
 }
 
 /**
@@ -91,17 +96,25 @@ func (e etcdError) Error() string {
   return e.Message
 }
 
-/**
- * Key observer
- */
-type etcdObserver func(string, interface{})
-
 /**
  * An etcd backed configuration
  */
 type EtcdConfig struct {
-  endpoint    *url.URL
+  cluster     *etcdCluster
   cache       *etcdCache
+  consistency Consistency
+  staleness   time.Duration
+  cacheFile   string
+  stale       int32
+  multiplex   []string
+  retry       RetryPolicy
+  watchRetry  RetryPolicy
+  client      *http.Client
+  logger      Logger
+  metrics     Metrics
+  sensitive   []string
+  username    string
+  password    string
   timeout     time.Duration
 }
 
@@ -109,16 +122,84 @@ type EtcdConfig struct {
  * Create an etcd-backed configuration
  */
 func NewEtcdConfig(endpoint string, timeout time.Duration) (*EtcdConfig, error) {
+  return NewEtcdClusterConfig([]string{endpoint}, timeout)
+}
+
+/**
+ * Create an etcd-backed configuration which uses the provided cluster members. Requests
+ * are made to one member at a time; if that member becomes unavailable, requests fail
+ * over to the next healthy member.
+ */
+func NewEtcdClusterConfig(endpoints []string, timeout time.Duration) (*EtcdConfig, error) {
+  return NewEtcdConfigWithOptions(endpoints, &EtcdOptions{Timeout:timeout})
+}
+
+/**
+ * Create an etcd-backed configuration which uses the provided cluster members and which
+ * is configured by the provided options.
+ */
+func NewEtcdConfigWithOptions(endpoints []string, opts *EtcdOptions) (*EtcdConfig, error) {
+  if opts == nil {
+    opts = &EtcdOptions{}
+  }
   
-  u, err := url.Parse(endpoint)
+  cluster, err := newEtcdCluster(endpoints)
   if err != nil {
     return nil, err
   }
   
+  client, err := opts.httpClient()
+  if err != nil {
+    return nil, err
+  }
+  
+  timeout := opts.Timeout
+  if timeout <= 0 {
+    timeout = etcdDefaultTimeout
+  }
+  
   etcd := &EtcdConfig{}
-  etcd.endpoint = u
+  etcd.cluster = cluster
   etcd.cache = newEtcdCache(etcd)
+  etcd.cache.maxEntries = opts.MaxCacheEntries
+  etcd.cache.maxAge = opts.MaxCacheAge
+  etcd.multiplex = opts.WatchPrefixes
+  etcd.client = client
+  etcd.username = opts.Username
+  etcd.password = opts.Password
+  etcd.sensitive = opts.SensitivePrefixes
+  
+  etcd.logger = opts.Logger
+  if etcd.logger == nil {
+    etcd.logger = defaultLogger
+  }
+  
+  etcd.metrics = opts.Metrics
+  if etcd.metrics == nil {
+    etcd.metrics = nopMetrics{}
+  }
   etcd.timeout = timeout
+  etcd.consistency = opts.Consistency
+  etcd.staleness = opts.MaxStaleness
+  
+  if opts.CacheFile != "" {
+    err = etcd.cache.load(opts.CacheFile)
+    if err != nil {
+      return nil, err
+    }
+    etcd.cacheFile = opts.CacheFile
+    etcd.cache.dirty = make(chan struct{}, 1)
+    go etcd.cache.persist(opts.CacheFile)
+  }
+  
+  etcd.retry = opts.Retry
+  if etcd.retry == nil {
+    etcd.retry = defaultRequestRetryPolicy()
+  }
+  etcd.watchRetry = opts.WatchRetry
+  if etcd.watchRetry == nil {
+    etcd.watchRetry = defaultWatchRetryPolicy()
+  }
   
   return etcd, nil
 }
@@ -126,14 +207,16 @@ func NewEtcdConfig(endpoint string, timeout time.Duration) (*EtcdConfig, error)
 /**
  * Obtain a configuration node
  */
-func (e *EtcdConfig) get(key string, wait, recurse bool, prev *etcdResponse, timeout time.Duration) (*etcdResponse, error) {
+func (e *EtcdConfig) get(ctx context.Context, key string, wait, recurse bool, index int64, timeout time.Duration) (*etcdResponse, error) {
   var u string
   
   path := keyToEtcdPath(key)
-  if !wait {
+  if !wait && recurse {
+    u = fmt.Sprintf("/v2/keys/%s?recursive=true", path)
+  }else if !wait {
     u = fmt.Sprintf("/v2/keys/%s", path)
-  }else if prev != nil {
-    u = fmt.Sprintf("/v2/keys/%s?wait=true&waitIndex=%d&recursive=%v", path, prev.Node.Modified + 1, recurse)
+  }else if index > 0 {
+    u = fmt.Sprintf("/v2/keys/%s?wait=true&waitIndex=%d&recursive=%v", path, index, recurse)
   }else{
     u = fmt.Sprintf("/v2/keys/%s?wait=true&recursive=%v", path, recurse)
   }
@@ -143,9 +226,6 @@ func (e *EtcdConfig) get(key string, wait, recurse bool, prev *etcdResponse, tim
     return nil, err
   }
   
-  abs := e.endpoint.ResolveReference(rel)
-  log.Printf("[%s] GET %s", key, abs.String())
-  
   var t time.Duration
   if wait {
     t = time.Minute
@@ -155,20 +235,53 @@ func (e *EtcdConfig) get(key string, wait, recurse bool, prev *etcdResponse, tim
     t = e.timeout
   }
   
-  req, err := http.NewRequest("GET", abs.String(), nil)
-  if err != nil {
-    return nil, err
+  // watches handle their own retries
+  retry := e.retry
+  if wait {
+    retry = NoRetry
   }
   
-  rsp, err := performRequest(req, t)
-  if rsp != nil {
-    defer rsp.Body.Close()
+  return e.request(ctx, key, "GET", rel, nil, t, true, retry)
+}
+
+/**
+ * Wait until a key is removed, either because it was deleted or because it expired.
+ * If the key does not exist this method returns immediately.
+ */
+func (e *EtcdConfig) waitForRemoval(ctx context.Context, key string) error {
+  
+  rsp, err := e.get(ctx, key, false, false, 0, 0)
+  if err == NoSuchKeyError {
+    return nil
+  }else if err != nil {
+    return err
   }
-  if err != nil {
-    return nil, err
+  
+  index := rsp.Node.Modified
+  for {
+    rsp, err = e.get(ctx, key, true, false, index + 1, 0)
+    if err == io.EOF || err == io.ErrUnexpectedEOF || err == TimeoutError {
+      continue
+    }else if err == EventIndexClearedError {
+      // we fell too far behind; find out where things stand now
+      rsp, err = e.get(ctx, key, false, false, 0, 0)
+      if err == NoSuchKeyError {
+        return nil
+      }else if err != nil {
+        return err
+      }
+      index = rsp.Index
+      continue
+    }else if err != nil {
+      return err
+    }
+    switch rsp.Action {
+      case ActionDelete, ActionExpire, ActionCompareAndDelete:
+        return nil
+    }
+    index = rsp.Node.Modified
   }
   
-  return handleResponse(rsp)
 }
 
 /**
@@ -176,10 +289,33 @@ func (e *EtcdConfig) get(key string, wait, recurse bool, prev *etcdResponse, tim
  * operations. This method will block until it either succeeds or fails.
  */
 func (e *EtcdConfig) GetWithIndex(key string) (interface{}, int64, error) {
-  var res interface{}
   
-  // always fetch, don't use the cache on get anymore
-  rsp, err := e.get(key, false, false, nil, 0)
+  // keys we are watching may be answered from the cache if we're allowed to
+  if e.consistency == ConsistencyWatched {
+    if rsp, ok := e.cache.GetCurrent(key, e.staleness); ok {
+      switch rsp.Action {
+        case ActionDelete, ActionExpire, ActionCompareAndDelete:
+          return nil, -1, NoSuchKeyError
+        default:
+          return responseValue(rsp)
+      }
+    }
+  }
+  
+  // otherwise, fetch; if the cache is persisted and we can't reach the cluster, fall
+  // back to the last known state of the key
+  rsp, err := e.get(context.Background(), key, false, false, 0, 0)
+  if err != nil && e.cacheFile != "" && IsRetryable(err) {
+    if rsp, ok := e.cache.Get(key); ok && rsp != nil && rsp.Node != nil {
+      e.setStale(true)
+      switch rsp.Action {
+        case ActionDelete, ActionExpire, ActionCompareAndDelete:
+          return nil, -1, NoSuchKeyError
+        default:
+          return responseValue(rsp)
+      }
+    }
+  }
   if err != nil {
     return nil, -1, err
   }else if rsp.Node == nil {
@@ -188,6 +324,90 @@ func (e *EtcdConfig) GetWithIndex(key string) (interface{}, int64, error) {
     e.cache.Set(key, rsp)
   }
   
+  return responseValue(rsp)
+}
+
+/**
+ * Describe where a key is defined, which for etcd is the index at which it was last
+ * modified.
+ */
+func (e *EtcdConfig) Locate(key string) (*Location, error) {
+  _, index, err := e.GetWithIndex(key)
+  if err != nil {
+    return nil, err
+  }
+  return &Location{Index:index}, nil
+}
+
+/**
+ * List the keys which are equal to or under the provided prefix. Only keys which hold
+ * values are listed, not directories.
+ */
+func (e *EtcdConfig) Keys(prefix string) ([]string, error) {
+  nodes, err := e.list(prefix)
+  if err != nil {
+    return nil, err
+  }
+  keys := make([]string, len(nodes))
+  for i, n := range nodes {
+    keys[i] = etcdPathToKey(n.Key)
+  }
+  return keys, nil
+}
+
+/**
+ * Obtain the values of the keys which are equal to or under the provided prefix. Every
+ * value is read by a single request, so they reflect the same state of the cluster.
+ */
+func (e *EtcdConfig) Values(prefix string) (map[string]interface{}, error) {
+  nodes, err := e.list(prefix)
+  if err != nil {
+    return nil, err
+  }
+  vals := make(map[string]interface{})
+  for _, n := range nodes {
+    v, err := n.Value()
+    if err != nil {
+      return nil, err
+    }
+    vals[etcdPathToKey(n.Key)] = v
+  }
+  return vals, nil
+}
+
+/**
+ * Read the nodes which hold values under the provided prefix
+ */
+func (e *EtcdConfig) list(prefix string) ([]*etcdNode, error) {
+  rsp, err := e.get(context.Background(), prefix, false, true, 0, 0)
+  if err == NoSuchKeyError {
+    return nil, nil
+  }else if err != nil {
+    return nil, err
+  }
+  nodes := make([]*etcdNode, 0)
+  var collect func(*etcdNode)
+  collect = func(n *etcdNode) {
+    if n == nil {
+      return
+    }else if !n.Directory {
+      nodes = append(nodes, n)
+    }
+    for _, c := range n.Subnodes {
+      collect(c)
+    }
+  }
+  collect(rsp.Node)
+  return nodes, nil
+}
+
+/**
+ * Obtain the value of a response and its modification index
+ */
+func responseValue(rsp *etcdResponse) (interface{}, int64, error) {
+  var res interface{}
+  var err error
+  
   // obtain our result value
   if rsp.Node.Directory && rsp.Node.Subnodes != nil {
     values := make([]interface{}, len(rsp.Node.Subnodes))
@@ -220,15 +440,44 @@ func (e *EtcdConfig) Get(key string) (interface{}, error) {
 /**
  * Watch a configuration value for changes asynchronously.
  */
-func (e *EtcdConfig) Watch(key string, observer etcdObserver) {
+func (e *EtcdConfig) Watch(key string, observer Observer) {
+  e.WatchEvents(key, func(v *Event) {
+    observer(v.Key, v.Value)
+  })
+}
+
+/**
+ * Watch a configuration value for change events asynchronously. Unlike Watch, the
+ * observer is told what kind of change occurred (e.g., a key which expires produces
+ * an event with the action ActionExpire).
+ */
+func (e *EtcdConfig) WatchEvents(key string, observer EventObserver) {
   // TODO: why is this done in such a roundabout way?
   e.cache.AddObserver(key, observer)
 }
 
 /**
- * Set a configuration value
+ * Determine the prefix under which watches of the provided key are multiplexed, if any.
+ * If several prefixes apply, the shortest is used so that as many watches as possible
+ * share a connection.
  */
-func (e *EtcdConfig) set(key, method string, dir bool, value, prevValue interface{}, prevIndex int64, timeout time.Duration) (*etcdResponse, error) {
+func (e *EtcdConfig) watchPrefix(key string) (string, bool) {
+  var prefix string
+  var found bool
+  for _, p := range e.multiplex {
+    if keyHasPrefix(key, []string{p}) && (!found || len(p) < len(prefix)) {
+      prefix, found = p, true
+    }
+  }
+  return prefix, found
+}
+
+/**
+ * Set a configuration value. If a TTL is provided the key expires after that duration
+ * unless it is refreshed. A refresh updates the TTL of an existing key without changing
+ * its value (and without notifying watchers).
+ */
+func (e *EtcdConfig) set(ctx context.Context, key, method string, dir bool, value, prevValue interface{}, prevIndex int64, ttl time.Duration, refresh bool, timeout time.Duration) (*etcdResponse, error) {
   
   rel, err := url.Parse(fmt.Sprintf("/v2/keys/%s", keyToEtcdPath(key)))
   if err != nil {
@@ -239,10 +488,21 @@ func (e *EtcdConfig) set(key, method string, dir bool, value, prevValue interfac
   vals := url.Values{}
   if dir {
     vals.Set("dir", "true")
-  }else{
+  }else if !refresh {
     vals.Set("value", encodeValue(value))
   }
   
+  // add the TTL, if any; etcd only deals in whole seconds so round up
+  if ttl > 0 {
+    vals.Set("ttl", encodeValue(int64((ttl + time.Second - 1) / time.Second)))
+  }
+  
+  // a refresh only applies to a key that already exists
+  if refresh {
+    vals.Set("refresh", "true")
+    vals.Set("prevExist", "true")
+  }
+  
   // if a previous node is provided, an atomic compare-and-swap update is performed
   if prevIndex > 0 {
     vals.Set("prevIndex", encodeValue(prevIndex))
@@ -252,17 +512,6 @@ func (e *EtcdConfig) set(key, method string, dir bool, value, prevValue interfac
     vals.Set("prevValue", encodeValue(prevValue))
   }
   
-  abs := e.endpoint.ResolveReference(rel)
-  req, err := http.NewRequest(method, abs.String(), strings.NewReader(vals.Encode()))
-  if err != nil {
-    return nil, err
-  }
-  
-  req.Header.Add("Content-Type", CONTENT_TYPE_FORM_ENCODED)
-  
-  log.Printf("[%s] PUT %s", key, abs.String())
-  log.Printf("[%s]   > %s", key, vals.Encode())
-  
   var t time.Duration
   if timeout > 0 {
     t = timeout
@@ -270,15 +519,10 @@ func (e *EtcdConfig) set(key, method string, dir bool, value, prevValue interfac
     t = e.timeout
   }
   
-  rsp, err := performRequest(req, t)
-  if rsp != nil {
-    defer rsp.Body.Close()
-  }
-  if err != nil {
-    return nil, err
-  }
+  // conditional updates are not safe to repeat once they may have been applied
+  idempotent := prevIndex == 0 && prevValue == nil
   
-  return handleResponse(rsp)
+  return e.request(ctx, key, method, rel, vals, t, idempotent, e.retry)
 }
 
 /**
@@ -288,7 +532,7 @@ func (e *EtcdConfig) set(key, method string, dir bool, value, prevValue interfac
  */
 func (e *EtcdConfig) SetWithIndex(key string, value interface{}) (interface{}, int64, error) {
   
-  rsp, err := e.set(key, "PUT", false, value, nil, 0, 0)
+  rsp, err := e.set(context.Background(), key, "PUT", false, value, nil, 0, 0, false, 0)
   if err != nil {
     return nil, -1, err
   }else if rsp.Node == nil {
@@ -313,6 +557,46 @@ func (e *EtcdConfig) Set(key string, value interface{}) (interface{}, error) {
   return v, err
 }
 
+/**
+ * Set a configuration value which expires after the provided TTL unless it is refreshed
+ * or set again. When the key expires, an ActionExpire event is delivered to anyone
+ * watching it. This method will block until it either succeeds or fails.
+ */
+func (e *EtcdConfig) SetWithTTL(key string, value interface{}, ttl time.Duration) (interface{}, error) {
+  if ttl <= 0 {
+    return nil, InvalidTTLError
+  }
+  
+  rsp, err := e.set(context.Background(), key, "PUT", false, value, nil, 0, ttl, false, 0)
+  if err != nil {
+    return nil, err
+  }else if rsp.Node == nil {
+    return nil, NoSuchKeyError
+  }
+  
+  e.cache.Set(key, rsp)
+  
+  return rsp.Node.Value()
+}
+
+/**
+ * Refresh the TTL of a key without changing its value. Watchers are not notified of
+ * a refresh. This method will block until it either succeeds or fails.
+ */
+func (e *EtcdConfig) RefreshTTL(key string, ttl time.Duration) error {
+  if ttl <= 0 {
+    return InvalidTTLError
+  }
+  
+  rsp, err := e.set(context.Background(), key, "PUT", false, nil, nil, 0, ttl, true, 0)
+  if err != nil {
+    return err
+  }
+  
+  e.cache.Set(key, rsp)
+  return nil
+}
+
 /**
  * Set a configuration value via an atomic compare-and-swap operation. This method will
  * block until it either succeeds or fails.
@@ -327,7 +611,7 @@ func (e *EtcdConfig) CompareAndSwap(key string, value interface{}, prev int64) (
     return nil, -1, InvalidIndexError
   }
   
-  rsp, err := e.set(key, "PUT", false, value, nil, prev, 0)
+  rsp, err := e.set(context.Background(), key, "PUT", false, value, nil, prev, 0, false, 0)
   if err != nil {
     return nil, -1, err
   }else if rsp.Node == nil {
@@ -345,45 +629,122 @@ func (e *EtcdConfig) CompareAndSwap(key string, value interface{}, prev int64) (
 }
 
 /**
- * Delete a configuration node
+ * Delete a configuration node. If a previous value or index is provided, an atomic
+ * compare-and-delete is performed.
  */
-func (e *EtcdConfig) delete(key string) (*etcdResponse, error) {
+func (e *EtcdConfig) delete(ctx context.Context, key string, prevValue interface{}, prevIndex int64) (*etcdResponse, error) {
   
   rel, err := url.Parse(fmt.Sprintf("/v2/keys/%s", keyToEtcdPath(key)))
   if err != nil {
     return nil, err
   }
   
-  abs := e.endpoint.ResolveReference(rel)
-  req, err := http.NewRequest("DELETE", abs.String(), nil)
-  if err != nil {
-    return nil, err
+  vals := url.Values{}
+  if prevIndex > 0 {
+    vals.Set("prevIndex", encodeValue(prevIndex))
+  }else if prevValue != nil {
+    vals.Set("prevValue", encodeValue(prevValue))
   }
   
-  log.Printf("[%s] DELETE %s", key, abs.String())
-  rsp, err := httpClient.Do(req)
-  if rsp != nil {
-    defer rsp.Body.Close() // always close Body
-  }
-  if err != nil {
-    return nil, err
-  }
+  rel.RawQuery = vals.Encode()
+  
+  // conditional deletes are not safe to repeat once they may have been applied
+  idempotent := prevIndex == 0 && prevValue == nil
   
-  return handleResponse(rsp)
+  return e.request(ctx, key, "DELETE", rel, nil, e.timeout, idempotent, e.retry)
 }
 
 /**
  * Delete a configuration key/value. This method will block until it either succeeds or fails.
  */
 func (e *EtcdConfig) Delete(key string) error {
+  _, err := e.DeleteWithIndex(key)
+  return err
+}
+
+/**
+ * Delete a configuration key/value and obtain the index at which it was deleted. This
+ * method will block until it either succeeds or fails.
+ */
+func (e *EtcdConfig) DeleteWithIndex(key string) (int64, error) {
   
-  rsp, err := e.delete(key)
+  rsp, err := e.delete(context.Background(), key, nil, 0)
   if err != nil {
-    return err
+    return -1, err
   }
   
-  e.cache.Set(key, rsp)
-  return nil
+  e.cache.Remove(key, rsp)
+  if rsp.Node == nil {
+    return rsp.Index, nil
+  }
+  return rsp.Node.Modified, nil
+}
+
+/**
+ * Perform a request and handle its response, retrying according to the provided policy.
+ * A request which is not idempotent is only retried if it could not be sent at all, since
+ * otherwise it may already have been applied.
+ */
+func (e *EtcdConfig) request(ctx context.Context, key, method string, rel *url.URL, form url.Values, timeout time.Duration, idempotent bool, retry RetryPolicy) (etcrsp *etcdResponse, err error) {
+  op := requestOperation(method, rel)
+  
+  start := time.Now()
+  defer func(){
+    e.metrics.Observe(MetricRequestDuration, time.Since(start).Seconds(), "op", op)
+    if err != nil && !(op == "watch" && err == TimeoutError) { // watches time out routinely
+      e.metrics.Counter(MetricRequestErrors, 1, "op", op, "type", errorType(err))
+    }
+  }()
+  
+  for attempt := 1; ; attempt++ {
+    
+    var rsp *http.Response
+    var data []byte
+    etcrsp = nil
+    rsp, data, err = e.perform(ctx, key, method, rel, form, timeout, idempotent)
+    if err == nil {
+      if e.cacheFile != "" {
+        e.setStale(false)
+      }
+      etcrsp, err = handleResponse(rsp, data)
+      if err == nil {
+        return etcrsp, nil
+      }
+    }
+    
+    if !idempotent && !isConnectError(err) {
+      return etcrsp, err
+    }
+    
+    delay, ok := retry.Backoff(attempt, err)
+    if !ok {
+      return etcrsp, err
+    }
+    
+    e.logger.Logf(LevelWarn, "[%s] Request failed (retrying in %v): %v", key, delay, err)
+    if !waitForRetry(ctx, delay) {
+      return etcrsp, err
+    }
+    
+  }
+}
+
+/**
+ * Describe the operation a request performs, for metrics
+ */
+func requestOperation(method string, rel *url.URL) string {
+  switch method {
+    case "GET":
+      if rel.Query().Get("wait") == "true" {
+        return "watch"
+      }else{
+        return "get"
+      }
+    case "PUT":
+      return "set"
+    default:
+      return strings.ToLower(method)
+  }
 }
 
 /**
@@ -404,17 +765,41 @@ func keyToEtcdPath(key string) string {
 }
 
 /**
- * Perform a request
+ * Translate a path to a key. This is the inverse of keyToEtcdPath.
  */
-func performRequest(req *http.Request, timeout time.Duration) (*http.Response, error) {
+func etcdPathToKey(path string) string {
+  var key string
+  
+  parts := strings.Split(strings.Trim(path, "/"), "/")
+  
+  for i, p := range parts {
+    if i > 0 { key += "." }
+    if u, err := url.QueryUnescape(p); err == nil {
+      key += u
+    }else{
+      key += p
+    }
+  }
+  
+  return key
+}
+
+/**
+ * Perform a request. The request is canceled if it does not complete within the
+ * timeout or if the context is canceled first.
+ */
+func performRequest(ctx context.Context, client *http.Client, req *http.Request, timeout time.Duration) (*http.Response, error) {
   var rsp *http.Response
   var err error
   
-  crsp := make(chan *http.Response)
-  cerr := make(chan error)
+  ctx, cancel := context.WithCancel(ctx)
+  req = req.WithContext(ctx)
+  
+  crsp := make(chan *http.Response, 1)
+  cerr := make(chan error, 1)
   
   go func(){
-    rsp, err := httpClient.Do(req)
+    rsp, err := client.Do(req)
     if err != nil {
       if rsp != nil { rsp.Body.Close() }
       cerr <- err
@@ -425,24 +810,42 @@ func performRequest(req *http.Request, timeout time.Duration) (*http.Response, e
   
   select {
     case rsp = <- crsp:
+      rsp.Body = &cancelingReadCloser{rsp.Body, cancel}
       return rsp, nil
     case err = <- cerr:
+      cancel()
       return nil, err
+    case <- ctx.Done():
+      cancel()
+      return nil, ctx.Err()
     case <- time.After(timeout):
-      httpClient.Transport.(*http.Transport).CancelRequest(req)
+      cancel()
       return nil, TimeoutError
   }
 }
 
+/**
+ * A response body which releases its request context when it is closed
+ */
+type cancelingReadCloser struct {
+  io.ReadCloser
+  cancel  context.CancelFunc
+}
+
+/**
+ * Close the body and release the request context
+ */
+func (r *cancelingReadCloser) Close() error {
+  err := r.ReadCloser.Close()
+  r.cancel()
+  return err
+}
+
 /**
  * Read a response
  */
-func handleResponse(rsp *http.Response) (*etcdResponse, error) {
-  
-  data, err := ioutil.ReadAll(rsp.Body)
-  if err != nil {
-    return nil, err
-  }
+func handleResponse(rsp *http.Response, data []byte) (*etcdResponse, error) {
+  var err error
   
   switch rsp.StatusCode {
     
@@ -451,12 +854,13 @@ func handleResponse(rsp *http.Response) (*etcdResponse, error) {
       err = json.Unmarshal(data, etcrsp)
       if err != nil {
         return nil, err
-      }else{
-        return etcrsp, nil
       }
+      etcrsp.Index = responseIndex(rsp)
+      return etcrsp, nil
       
     case http.StatusNotFound:
-      return nil, NoSuchKeyError
+      // the response carries no node, but the index tells us when the key was missing
+      return &etcdResponse{Action:"get", Index:responseIndex(rsp)}, NoSuchKeyError
       
     default:
       etcerr := &etcdError{}
@@ -471,14 +875,30 @@ func handleResponse(rsp *http.Response) (*etcdResponse, error) {
   
 }
 
+/**
+ * Obtain the etcd index at the time a response was produced
+ */
+func responseIndex(rsp *http.Response) int64 {
+  var index int64
+  if v := rsp.Header.Get("X-Etcd-Index"); v != "" {
+    index, _ = strconv.ParseInt(v, 10, 64)
+  }
+  return index
+}
+
 /**
  * Attempt to normalize an error
  */
 func normalizeError(err *etcdError) error {
-  if err.Code == 101 {
-    return ComparisonFailedError
-  }else{
-    return err
+  switch err.Code {
+    case 101:
+      return ComparisonFailedError
+    case 105:
+      return KeyCollisionError
+    case 401:
+      return EventIndexClearedError
+    default:
+      return err
   }
 }
 
@@ -32,11 +32,18 @@ package conf
 
 import (
   "io"
-  "log"
   "time"
   "sync"
+  "context"
+  "strings"
+  "container/list"
 )
 
+/**
+ * The approximate overhead of a cached node, beyond its key and value, in bytes
+ */
+const etcdNodeOverhead = 128
+
 /**
  * Cache
  */
@@ -44,16 +51,58 @@ type etcdCacheEntry struct {
   sync.RWMutex
   key         string
   response    *etcdResponse
+  recursive   bool
   watching    bool
-  observers   []etcdObserver
+  failing     time.Time
+  observers   []EventObserver
   finalize    chan struct{}
+  accessed    time.Time
+  element     *list.Element
+  multiplexed bool            // this entry's watch delivers events for the keys under it
+  parent      *etcdCacheEntry // the multiplexed watch which delivers events for this entry
 }
 
 /**
  * Create a cache entry
  */
 func newEtcdCacheEntry(key string, rsp *etcdResponse) *etcdCacheEntry {
-  return &etcdCacheEntry{key: key, response:rsp, observers: make([]etcdObserver, 0)}
+  return &etcdCacheEntry{key: key, response:rsp, observers: make([]EventObserver, 0), accessed: time.Now()}
+}
+
+/**
+ * Determine if this entry may be evicted, which is the case if nobody is watching it
+ */
+func (e *etcdCacheEntry) evictable() bool {
+  e.RLock()
+  defer e.RUnlock()
+  return !e.watching && !e.recursive && len(e.observers) == 0
+}
+
+/**
+ * Estimate the memory used by this entry, in bytes
+ */
+func (e *etcdCacheEntry) size() int64 {
+  e.RLock()
+  defer e.RUnlock()
+  n := int64(len(e.key))
+  if e.response != nil {
+    n += nodeSize(e.response.Node) + nodeSize(e.response.Previous)
+  }
+  return n
+}
+
+/**
+ * Estimate the memory used by a node and its descendants, in bytes
+ */
+func nodeSize(n *etcdNode) int64 {
+  if n == nil {
+    return 0
+  }
+  s := int64(etcdNodeOverhead + len(n.Key) + len(n.Encoded))
+  for _, e := range n.Subnodes {
+    s += nodeSize(e)
+  }
+  return s
 }
 
 /**
@@ -66,31 +115,101 @@ func (e *etcdCacheEntry) Response() *etcdResponse {
 }
 
 /**
- * Set the response
+ * Set the response. If we already have a response which describes a later state of the
+ * key (e.g., because a watch event arrived while a read was in flight), it is kept.
  */
 func (e *etcdCacheEntry) SetResponse(rsp *etcdResponse) {
   e.Lock()
   defer e.Unlock()
-  e.response = rsp
+  if !supersedes(e.response, rsp) {
+    e.response = rsp
+  }
+}
+
+/**
+ * Determine if a response describes a later state of a key than another
+ */
+func supersedes(a, b *etcdResponse) bool {
+  return a != nil && a.Node != nil && b != nil && b.Node != nil && a.Node.Modified > b.Node.Modified
+}
+
+/**
+ * Obtain the response if it can be trusted to reflect the current state of the key. This
+ * is the case if we are watching the key and the watch has not been failing for longer
+ * than the provided staleness bound.
+ */
+func (e *etcdCacheEntry) Current(staleness time.Duration) (*etcdResponse, bool) {
+  e.RLock()
+  defer e.RUnlock()
+  
+  // a multiplexed entry is only as healthy as the watch which delivers its events
+  watching, failing := e.watching, e.failing
+  if e.parent != nil {
+    watching, failing = e.parent.status()
+  }
+  
+  if !watching || e.recursive || e.response == nil || e.response.Node == nil || e.response.Node.Directory {
+    return nil, false
+  }
+  if !failing.IsZero() && time.Since(failing) > staleness {
+    return nil, false
+  }
+  return e.response, true
+}
+
+/**
+ * Obtain the status of this entry's watch
+ */
+func (e *etcdCacheEntry) status() (bool, time.Time) {
+  e.RLock()
+  defer e.RUnlock()
+  return e.watching, e.failing
+}
+
+/**
+ * Note whether the watch is failing
+ */
+func (e *etcdCacheEntry) setFailing(failing bool) {
+  e.Lock()
+  defer e.Unlock()
+  if !failing {
+    e.failing = time.Time{}
+  }else if e.failing.IsZero() {
+    e.failing = time.Now()
+  }
 }
 
 /**
  * Add an observer for this entry and begin watching if we aren't already
  */
-func (e *etcdCacheEntry) AddObserver(c *EtcdConfig, observer etcdObserver) {
+func (e *etcdCacheEntry) AddObserver(c *EtcdConfig, observer EventObserver) {
   e.Lock()
   defer e.Unlock()
   e.observers = append(e.observers, observer)
   e.startWatching(c)
 }
 
+/**
+ * Add an observer for this entry, whose events are delivered by the provided multiplexed
+ * watch. If we are already watching this entry ourselves, that watch is used instead.
+ */
+func (e *etcdCacheEntry) AddMultiplexedObserver(parent *etcdCacheEntry, observer EventObserver) {
+  e.Lock()
+  defer e.Unlock()
+  e.observers = append(e.observers, observer)
+  if !e.watching {
+    e.parent = parent
+    e.watching = true
+  }
+}
+
 /**
  * Remove all observers for this entry
  */
 func (e *etcdCacheEntry) RemoveAllObservers() {
   e.Lock()
   defer e.Unlock()
-  e.observers = make([]etcdObserver, 0)
+  e.observers = make([]EventObserver, 0)
 }
 
 /**
@@ -130,57 +249,186 @@ func (e *etcdCacheEntry) startWatching(c *EtcdConfig) {
  */
 func (e *etcdCacheEntry) watch(c *EtcdConfig) {
   errcount := 0
-  backoff  := time.Second
-  maxboff  := time.Second * 15
+  
+  // the last state the watch itself observed; our response may describe a later state
+  // (e.g., after a write of our own), but we must not skip the events in between
+  var since *etcdResponse
+  
   for {
     var err error
     
     e.RLock()
     key := e.key
     rsp := e.response
+    recurse := e.recursive
     e.RUnlock()
+    if since != nil {
+      rsp = since
+    }
+    
+    // a read tells us the state of the key as of the etcd index at the time it was made,
+    // whereas a watch event tells us about a change that happened at the node's index
+    var index int64
+    if rsp != nil && rsp.Action == "get" && rsp.Index > 0 {
+      index = rsp.Index + 1
+    }else if rsp != nil && rsp.Node != nil {
+      index = rsp.Node.Modified + 1
+    }
     
-    recurse := rsp != nil && rsp.Node != nil && rsp.Node.Directory
-    rsp, err = c.get(key, true, recurse, rsp, 0)
-    if err == io.EOF || err == io.ErrUnexpectedEOF || err == TimeoutError {
+    recurse = recurse || (rsp != nil && rsp.Node != nil && rsp.Node.Directory)
+    rsp, err = c.get(context.Background(), key, true, recurse, index, 0)
+    if err == EventIndexClearedError {
+      // we fell too far behind for etcd to tell us what we missed; read the current state
+      // and carry on from there
+      c.metrics.Counter(MetricWatchReconnects, 1)
+      c.logger.Logf(LevelWarn, "[%s] Watch fell behind; resynchronizing", key)
+      rsp, err = e.resync(c, key, recurse)
+      if err == nil {
+        since = rsp
+        errcount = 0
+        continue
+      }
+    }
+    if err == TimeoutError {
+      e.setFailing(false) // the long poll simply expired
+      errcount = 0
+      continue
+    }else if err == io.EOF || err == io.ErrUnexpectedEOF {
+      c.metrics.Counter(MetricWatchReconnects, 1)
+      e.setFailing(true)
       errcount = 0
       continue
     }else if err != nil {
+      c.metrics.Counter(MetricWatchReconnects, 1)
+      e.setFailing(true)
       errcount++
-      delay := backoff * time.Duration(errcount * errcount)
-      if delay > maxboff { delay = maxboff }
-      log.Printf("[%s] Could not watch (backing off %v) %v", key, delay, err)
+      delay, ok := c.watchRetry.Backoff(errcount, err)
+      if !ok {
+        c.logger.Logf(LevelError, "[%s] Could not watch (giving up) %v", key, err)
+        e.Lock()
+        e.watching = false
+        e.Unlock()
+        return
+      }
+      c.logger.Logf(LevelWarn, "[%s] Could not watch (backing off %v) %v", key, delay, err)
       <- time.After(delay)
       continue
     }
     
+    if rsp.Index > 0 && rsp.Node != nil {
+      c.metrics.Observe(MetricWatchLag, float64(rsp.Index - rsp.Node.Modified))
+    }
+    
     errcount = 0
+    since = rsp
     e.Lock()
-    e.response = rsp
+    if !supersedes(e.response, rsp) {
+      e.response = rsp
+    }
+    e.failing = time.Time{}
+    c.cache.changed()
     
-    var observers []etcdObserver
+    var observers []EventObserver
     if c := len(e.observers); c > 0 {
-      observers = make([]etcdObserver, c)
+      observers = make([]EventObserver, c)
       copy(observers, e.observers)
     }
     
     e.Unlock()
     
-    val, err := rsp.Node.Value()
+    event, err := newEtcdEvent(key, rsp)
     if err != nil {
-      log.Printf("[%s] Could not decode value (nobody will be notified): %v", key, err)
+      c.logger.Logf(LevelError, "[%s] Could not decode value (nobody will be notified): %v", key, err)
       continue
     }
     
+    if e.multiplexed {
+      c.cache.dispatch(e, rsp, event)
+    }
+    
     if observers != nil {
       for _, o := range observers {
-        go o(key, val)
+        go o(event)
       }
     }
     
   }
 }
 
+/**
+ * Read the current state of this entry, after which we continue watching from the index
+ * at which it was read. Observers are notified with a resync event. The state that was
+ * read is returned.
+ */
+func (e *etcdCacheEntry) resync(c *EtcdConfig, key string, recurse bool) (*etcdResponse, error) {
+  
+  rsp, err := c.get(context.Background(), key, false, recurse, 0, 0)
+  if err == NoSuchKeyError && rsp != nil {
+    // the key doesn't exist, but we know the index as of which that was true
+  }else if err != nil {
+    return nil, err
+  }
+  
+  event, err := newResyncEvent(key, rsp)
+  if err != nil {
+    return nil, err
+  }
+  
+  e.Lock()
+  e.response = rsp
+  e.failing = time.Time{}
+  observers := make([]EventObserver, len(e.observers))
+  copy(observers, e.observers)
+  e.Unlock()
+  
+  c.cache.changed()
+  if e.multiplexed {
+    c.cache.resync(e, rsp)
+  }
+  
+  for _, o := range observers {
+    go o(event)
+  }
+  
+  return rsp, nil
+}
+
+/**
+ * Create a resync event from a read. If the key does not exist the event carries no value.
+ */
+func newResyncEvent(key string, rsp *etcdResponse) (*Event, error) {
+  event := &Event{Action: ActionResync, Key: key, Index: rsp.Index}
+  if rsp.Node != nil {
+    val, _, err := responseValue(rsp)
+    if err != nil {
+      return nil, err
+    }
+    event.Value = val
+  }
+  return event, nil
+}
+
+/**
+ * Create an event from a watch response. Events which remove a key carry no value.
+ */
+func newEtcdEvent(key string, rsp *etcdResponse) (*Event, error) {
+  if rsp.Node.Key != "" {
+    key = etcdPathToKey(rsp.Node.Key) // for recursive watches, the key that actually changed
+  }
+  event := &Event{Action: rsp.Action, Key: key, Index: rsp.Node.Modified}
+  switch rsp.Action {
+    case ActionDelete, ActionExpire, ActionCompareAndDelete:
+      // no value
+    default:
+      val, err := rsp.Node.Value()
+      if err != nil {
+        return nil, err
+      }
+      event.Value = val
+  }
+  return event, nil
+}
+
 /**
  * Stop watching this entry for updates
  */
@@ -193,6 +441,16 @@ func (e *etcdCacheEntry) Cancel() {
   }
 }
 
+/**
+ * Cache statistics
+ */
+type CacheStats struct {
+  Entries     int     // the number of entries in the cache
+  Watched     int     // the number of entries which are being watched
+  Bytes       int64   // the approximate memory used by the cached responses
+  Evictions   int64   // the number of entries evicted since the cache was created
+}
+
 /**
  * Cache
  */
@@ -200,39 +458,153 @@ type etcdCache struct {
   sync.RWMutex
   config      *EtcdConfig
   props       map[string]*etcdCacheEntry
+  lru         *list.List
+  maxEntries  int
+  maxAge      time.Duration
+  evictions   int64
+  observers   int
+  prefixes    map[string]*etcdCacheEntry
+  dirty       chan struct{}
 }
 
 /**
  * Create a cache
  */
 func newEtcdCache(config *EtcdConfig) *etcdCache {
-  return &etcdCache{config: config, props: make(map[string]*etcdCacheEntry)}
+  return &etcdCache{config: config, props: make(map[string]*etcdCacheEntry), lru: list.New(), prefixes: make(map[string]*etcdCacheEntry)}
 }
 
 /**
  * Obtain a response from the cache
  */
 func (c *etcdCache) Get(key string) (*etcdResponse, bool) {
-  c.RLock()
-  defer c.RUnlock()
+  c.Lock()
+  defer c.Unlock()
   e, ok := c.props[key]
   if ok {
+    c.touch(e)
     return e.Response(), true
   }else{
     return nil, false
   }
 }
 
+/**
+ * Obtain statistics describing the cache
+ */
+func (e *EtcdConfig) CacheStats() CacheStats {
+  return e.cache.Stats()
+}
+
+/**
+ * Obtain cache statistics
+ */
+func (c *etcdCache) Stats() CacheStats {
+  c.Lock()
+  defer c.Unlock()
+  c.evict(nil)
+  stats := CacheStats{Entries: len(c.props), Evictions: c.evictions}
+  for _, e := range c.props {
+    if !e.evictable() {
+      stats.Watched++
+    }
+    stats.Bytes += e.size()
+  }
+  return stats
+}
+
+/**
+ * Add an entry to the cache (no sync)
+ */
+func (c *etcdCache) insert(e *etcdCacheEntry) {
+  c.props[e.key] = e
+  e.element = c.lru.PushFront(e)
+}
+
+/**
+ * Remove an entry from the cache (no sync)
+ */
+func (c *etcdCache) remove(e *etcdCacheEntry) {
+  delete(c.props, e.key)
+  c.lru.Remove(e.element)
+}
+
+/**
+ * Note that an entry has been used (no sync)
+ */
+func (c *etcdCache) touch(e *etcdCacheEntry) {
+  e.Lock()
+  e.accessed = time.Now()
+  e.Unlock()
+  c.lru.MoveToFront(e.element)
+}
+
+/**
+ * Evict entries which are not being watched, least recently used first, until the cache
+ * is within its bounds. The provided entry, which the caller is about to use, is always
+ * kept (no sync)
+ */
+func (c *etcdCache) evict(keep *etcdCacheEntry) {
+  if c.maxEntries <= 0 && c.maxAge <= 0 {
+    return
+  }
+  
+  now := time.Now()
+  n := 0
+  for l := c.lru.Back(); l != nil; {
+    e := l.Value.(*etcdCacheEntry)
+    l = l.Prev()
+    
+    e.RLock()
+    accessed := e.accessed
+    e.RUnlock()
+    
+    over := c.maxEntries > 0 && len(c.props) > c.maxEntries
+    expired := c.maxAge > 0 && now.Sub(accessed) > c.maxAge
+    if !over && !expired {
+      break // everything else was used more recently
+    }
+    if e != keep && e.evictable() {
+      c.remove(e)
+      n++
+    }
+  }
+  
+  if n > 0 {
+    c.evictions += int64(n)
+    c.config.metrics.Counter(MetricCacheEvictions, float64(n))
+  }
+  c.config.metrics.Gauge(MetricCacheEntries, float64(len(c.props)))
+}
+
+/**
+ * Obtain a response from the cache if it can be trusted to reflect the current state of
+ * the key, which is only the case for keys we are watching.
+ */
+func (c *etcdCache) GetCurrent(key string, staleness time.Duration) (*etcdResponse, bool) {
+  c.RLock()
+  defer c.RUnlock()
+  if e, ok := c.props[key]; ok {
+    if rsp, ok := e.Current(staleness); ok {
+      c.config.metrics.Counter(MetricCacheHits, 1)
+      return rsp, true
+    }
+  }
+  c.config.metrics.Counter(MetricCacheMisses, 1)
+  return nil, false
+}
+
 /**
  * Get or create a cache entry. Returns (entry, created or not); (no sync)
  */
 func (c *etcdCache) getOrCreate(key string) (*etcdCacheEntry, bool) {
   e, ok := c.props[key]
   if ok {
+    c.touch(e)
     return e, false
   }else{
     e = newEtcdCacheEntry(key, nil)
-    c.props[key] = e
+    c.insert(e)
     return e, true
   }
 }
@@ -244,6 +616,7 @@ func (c *etcdCache) Set(key string, rsp *etcdResponse) {
   c.Lock()
   defer c.Unlock()
   c.set(key, rsp)
+  c.changed()
 }
 
 /**
@@ -253,10 +626,12 @@ func (c *etcdCache) set(key string, rsp *etcdResponse) *etcdCacheEntry {
   e, ok := c.props[key]
   if ok {
     e.SetResponse(rsp)
+    c.touch(e)
   }else{
     e = newEtcdCacheEntry(key, rsp)
-    c.props[key] = e
+    c.insert(e)
   }
+  c.evict(e)
   return e
 }
 
@@ -273,11 +648,158 @@ func (c *etcdCache) SetAndWatch(key string, rsp *etcdResponse) {
 /**
  * Add an observer and begin watching if necessary
  */
-func (c *etcdCache) AddObserver(key string, observer etcdObserver) {
+func (c *etcdCache) AddObserver(key string, observer EventObserver) {
+  c.Lock()
+  defer c.Unlock()
+  e, _ := c.getOrCreate(key)
+  if p, ok := c.config.watchPrefix(key); ok {
+    e.AddMultiplexedObserver(c.multiplexer(p), observer)
+  }else{
+    e.AddObserver(c.config, observer)
+  }
+  c.observers++
+  c.config.metrics.Gauge(MetricObservers, float64(c.observers))
+}
+
+/**
+ * Obtain the multiplexed watch for a prefix and begin watching if necessary (no sync)
+ */
+func (c *etcdCache) multiplexer(prefix string) *etcdCacheEntry {
+  m, ok := c.prefixes[prefix]
+  if !ok {
+    m = newEtcdCacheEntry(prefix, nil)
+    m.recursive = true
+    m.multiplexed = true
+    c.prefixes[prefix] = m
+  }
+  m.Watch(c.config)
+  return m
+}
+
+/**
+ * Deliver an event from a multiplexed watch to the entries it applies to: the key which
+ * changed and any of its ancestors under the watched prefix.
+ */
+func (c *etcdCache) dispatch(m *etcdCacheEntry, rsp *etcdResponse, event *Event) {
+  var targets []*etcdCacheEntry
+  
+  c.RLock()
+  for k := event.Key; keyHasPrefix(k, []string{m.key}); {
+    if e, ok := c.props[k]; ok {
+      targets = append(targets, e)
+    }
+    i := strings.LastIndex(k, ".")
+    if i < 0 {
+      break
+    }
+    k = k[:i]
+  }
+  c.RUnlock()
+  
+  for _, e := range targets {
+    e.Lock()
+    if e.parent != m {
+      e.Unlock()
+      continue
+    }
+    if e.key == event.Key && !supersedes(e.response, rsp) {
+      e.response = rsp // ancestors are notified, but their state is not the event's
+    }
+    observers := make([]EventObserver, len(e.observers))
+    copy(observers, e.observers)
+    e.Unlock()
+    for _, o := range observers {
+      go o(event)
+    }
+  }
+  
+  c.changed()
+}
+
+/**
+ * Apply the state read when a multiplexed watch was resynchronized to the entries it
+ * delivers events for and notify their observers.
+ */
+func (c *etcdCache) resync(m *etcdCacheEntry, rsp *etcdResponse) {
+  
+  nodes := make(map[string]*etcdNode)
+  var collect func(*etcdNode)
+  collect = func(n *etcdNode) {
+    if n != nil {
+      nodes[etcdPathToKey(n.Key)] = n
+      for _, e := range n.Subnodes {
+        collect(e)
+      }
+    }
+  }
+  collect(rsp.Node)
+  
+  var targets []*etcdCacheEntry
+  c.RLock()
+  for _, e := range c.props {
+    e.RLock()
+    if e.parent == m {
+      targets = append(targets, e)
+    }
+    e.RUnlock()
+  }
+  c.RUnlock()
+  
+  for _, e := range targets {
+    crsp := &etcdResponse{Action: "get", Node: nodes[e.key], Index: rsp.Index}
+    event, err := newResyncEvent(e.key, crsp)
+    if err != nil {
+      c.config.logger.Logf(LevelError, "[%s] Could not decode value (nobody will be notified): %v", e.key, err)
+      continue
+    }
+    
+    e.Lock()
+    e.response = crsp
+    observers := make([]EventObserver, len(e.observers))
+    copy(observers, e.observers)
+    e.Unlock()
+    
+    for _, o := range observers {
+      go o(event)
+    }
+  }
+  
+}
+
+/**
+ * Add an observer for a directory and all its descendants and begin watching if
+ * necessary. If a response is provided, the watch continues from it.
+ */
+func (c *etcdCache) AddDirectoryObserver(key string, rsp *etcdResponse, observer EventObserver) {
   c.Lock()
   defer c.Unlock()
   e, _ := c.getOrCreate(key)
+  e.Lock()
+  e.recursive = true
+  if rsp != nil {
+    e.response = rsp
+  }
+  e.Unlock()
   e.AddObserver(c.config, observer)
+  c.observers++
+  c.config.metrics.Gauge(MetricObservers, float64(c.observers))
+}
+
+/**
+ * Note that a key has been removed. If the key is being watched, the response is kept so
+ * that the removal is reflected in the cache; otherwise there's no point in keeping it.
+ */
+func (c *etcdCache) Remove(key string, rsp *etcdResponse) {
+  c.Lock()
+  defer c.Unlock()
+  if e, ok := c.props[key]; ok {
+    if e.evictable() {
+      c.remove(e)
+    }else{
+      e.SetResponse(rsp)
+    }
+    c.changed()
+  }
 }
 
 /**
@@ -286,5 +808,7 @@ func (c *etcdCache) AddObserver(key string, observer etcdObserver) {
 func (c *etcdCache) Delete(key string) {
   c.Lock()
   defer c.Unlock()
-  delete(c.props, key)
+  if e, ok := c.props[key]; ok {
+    c.remove(e)
+  }
 }
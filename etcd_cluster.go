@@ -0,0 +1,333 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "net"
+  "sort"
+  "sync"
+  "time"
+  "errors"
+  "context"
+  "strings"
+  "net/url"
+  "net/http"
+  "io/ioutil"
+  "encoding/json"
+)
+
+var NoEndpointsError = errors.New("No endpoints")
+
+/**
+ * How long a member which has failed is considered unhealthy before we try it again
+ * ahead of the members which have not failed.
+ */
+const etcdEndpointRetryInterval = time.Second * 30
+
+/**
+ * A cluster member endpoint
+ */
+type etcdEndpoint struct {
+  url         *url.URL
+  failed      time.Time
+}
+
+/**
+ * Determine if this endpoint is considered healthy
+ */
+func (p *etcdEndpoint) healthy(now time.Time) bool {
+  return p.failed.IsZero() || now.Sub(p.failed) > etcdEndpointRetryInterval
+}
+
+/**
+ * The members of an etcd cluster and their health
+ */
+type etcdCluster struct {
+  sync.Mutex
+  endpoints   []*etcdEndpoint
+}
+
+/**
+ * Create a cluster from endpoint URLs
+ */
+func newEtcdCluster(endpoints []string) (*etcdCluster, error) {
+  c := &etcdCluster{}
+  err := c.SetEndpoints(endpoints)
+  if err != nil {
+    return nil, err
+  }
+  return c, nil
+}
+
+/**
+ * Obtain the cluster member endpoint URLs
+ */
+func (c *etcdCluster) Endpoints() []string {
+  c.Lock()
+  defer c.Unlock()
+  endpoints := make([]string, len(c.endpoints))
+  for i, p := range c.endpoints {
+    endpoints[i] = p.url.String()
+  }
+  return endpoints
+}
+
+/**
+ * Replace the cluster member endpoints. The health of members we already know about
+ * is preserved.
+ */
+func (c *etcdCluster) SetEndpoints(endpoints []string) error {
+  if len(endpoints) < 1 {
+    return NoEndpointsError
+  }
+  
+  c.Lock()
+  defer c.Unlock()
+  
+  known := make(map[string]*etcdEndpoint)
+  for _, p := range c.endpoints {
+    known[p.url.String()] = p
+  }
+  
+  update := make([]*etcdEndpoint, len(endpoints))
+  for i, e := range endpoints {
+    u, err := url.Parse(e)
+    if err != nil {
+      return err
+    }
+    if p, ok := known[u.String()]; ok {
+      update[i] = p
+    }else{
+      update[i] = &etcdEndpoint{url:u}
+    }
+  }
+  
+  c.endpoints = update
+  return nil
+}
+
+/**
+ * Obtain the endpoints to try for a request, in order. Healthy members are tried first,
+ * beginning with the one which most recently succeeded; then members which have failed,
+ * beginning with the one which failed longest ago.
+ */
+func (c *etcdCluster) Candidates() []*etcdEndpoint {
+  c.Lock()
+  defer c.Unlock()
+  
+  now := time.Now()
+  healthy := make([]*etcdEndpoint, 0, len(c.endpoints))
+  failed := make([]*etcdEndpoint, 0)
+  
+  for _, p := range c.endpoints {
+    if p.healthy(now) {
+      healthy = append(healthy, p)
+    }else{
+      failed = append(failed, p)
+    }
+  }
+  
+  sort.Sort(endpointsByFailure(failed))
+  return append(healthy, failed...)
+}
+
+/**
+ * Note that a request to an endpoint succeeded. The endpoint is preferred for subsequent
+ * requests so we stick to one member for as long as it is available.
+ */
+func (c *etcdCluster) Succeeded(p *etcdEndpoint) {
+  c.Lock()
+  defer c.Unlock()
+  p.failed = time.Time{}
+  for i, e := range c.endpoints {
+    if e == p {
+      copy(c.endpoints[1:i+1], c.endpoints[:i])
+      c.endpoints[0] = p
+      break
+    }
+  }
+}
+
+/**
+ * Note that a request to an endpoint failed
+ */
+func (c *etcdCluster) Failed(p *etcdEndpoint) {
+  c.Lock()
+  defer c.Unlock()
+  p.failed = time.Now()
+}
+
+/**
+ * Sort endpoints by when they failed, oldest first
+ */
+type endpointsByFailure []*etcdEndpoint
+
+func (v endpointsByFailure) Len() int            { return len(v) }
+func (v endpointsByFailure) Swap(a, b int)       { v[a], v[b] = v[b], v[a] }
+func (v endpointsByFailure) Less(a, b int) bool  { return v[a].failed.Before(v[b].failed) }
+
+/**
+ * Obtain the cluster member endpoints currently in use
+ */
+func (e *EtcdConfig) Endpoints() []string {
+  return e.cluster.Endpoints()
+}
+
+/**
+ * Discover the cluster members from the cluster itself and use their client URLs as our
+ * endpoints from now on.
+ */
+func (e *EtcdConfig) SyncMembers(ctx context.Context) error {
+  
+  rel, err := url.Parse("/v2/members")
+  if err != nil {
+    return err
+  }
+  
+  rsp, data, err := e.perform(ctx, "", "GET", rel, nil, e.timeout, true)
+  if err != nil {
+    return err
+  }else if rsp.StatusCode != http.StatusOK {
+    return ServiceError
+  }
+  
+  members := struct {
+    Members []struct {
+      ClientURLs  []string `json:"clientURLs"`
+    }                      `json:"members"`
+  }{}
+  
+  err = json.Unmarshal(data, &members)
+  if err != nil {
+    return err
+  }
+  
+  endpoints := make([]string, 0)
+  for _, m := range members.Members {
+    endpoints = append(endpoints, m.ClientURLs...)
+  }
+  
+  return e.cluster.SetEndpoints(endpoints)
+}
+
+/**
+ * Perform a request against the cluster. If a member cannot be reached, does not respond
+ * in time or reports that it is unavailable the request fails over to the next candidate.
+ * Requests which are not idempotent only fail over when the member could not be reached
+ * at all, since otherwise they may already have been applied. A watch which times out is
+ * not a failure; the long-poll simply ended without a change.
+ */
+func (e *EtcdConfig) perform(ctx context.Context, key, method string, rel *url.URL, form url.Values, timeout time.Duration, idempotent bool) (*http.Response, []byte, error) {
+  var rsp *http.Response
+  var data []byte
+  var err error
+  
+  watch := requestOperation(method, rel) == "watch"
+  for _, p := range e.cluster.Candidates() {
+    
+    rsp, data, err = e.performOn(ctx, p, key, method, rel, form, timeout)
+    if err == nil && rsp.StatusCode < 500 {
+      e.cluster.Succeeded(p)
+      return rsp, data, nil
+    }else if ctx.Err() != nil {
+      return nil, nil, ctx.Err()
+    }else if err == TimeoutError && watch {
+      return nil, nil, err
+    }
+    
+    e.cluster.Failed(p)
+    if !idempotent && !isConnectError(err) {
+      break
+    }
+    
+    if err != nil {
+      e.logger.Logf(LevelWarn, "[%s] Member %v failed (trying the next one): %v", key, p.url, err)
+    }else{
+      e.logger.Logf(LevelWarn, "[%s] Member %v is unavailable (trying the next one): %v", key, p.url, rsp.Status)
+    }
+    
+  }
+  
+  return rsp, data, err
+}
+
+/**
+ * Perform a request against a single endpoint and read the response
+ */
+func (e *EtcdConfig) performOn(ctx context.Context, p *etcdEndpoint, key, method string, rel *url.URL, form url.Values, timeout time.Duration) (*http.Response, []byte, error) {
+  
+  var body io.Reader
+  if form != nil {
+    body = strings.NewReader(form.Encode())
+  }
+  
+  abs := p.url.ResolveReference(rel)
+  req, err := http.NewRequest(method, abs.String(), body)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  if form != nil {
+    req.Header.Add("Content-Type", CONTENT_TYPE_FORM_ENCODED)
+  }
+  if e.username != "" || e.password != "" {
+    req.SetBasicAuth(e.username, e.password)
+  }
+  
+  e.logger.Logf(LevelDebug, "[%s] %s %s", key, method, redactURL(key, abs, e.sensitive))
+  if form != nil {
+    e.logger.Logf(LevelDebug, "[%s]   > %s", key, redactValues(key, form, e.sensitive))
+  }
+  
+  rsp, err := performRequest(ctx, e.client, req, timeout)
+  if rsp != nil {
+    defer rsp.Body.Close()
+  }
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  data, err := ioutil.ReadAll(rsp.Body)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  return rsp, data, nil
+}
+
+/**
+ * Determine if an error indicates that a connection could not be established at all
+ */
+func isConnectError(err error) bool {
+  var operr *net.OpError
+  return errors.As(err, &operr) && operr.Op == "dial"
+}
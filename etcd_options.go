@@ -0,0 +1,217 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "time"
+  "errors"
+  "net/http"
+  "io/ioutil"
+  "crypto/tls"
+  "crypto/x509"
+)
+
+var InvalidCAError = errors.New("No certificates could be loaded from the CA bundle")
+
+/**
+ * Read consistency modes
+ */
+type Consistency int
+
+const (
+  ConsistencyStrong   Consistency = iota  // every read is made against the cluster
+  ConsistencyWatched                      // reads of keys we are watching are served from the cache
+)
+
+/**
+ * Options for an etcd-backed configuration
+ */
+type EtcdOptions struct {
+  
+  /**
+   * The default request timeout. Watches are not subject to this timeout.
+   */
+  Timeout       time.Duration
+  
+  /**
+   * How reads are served. By default every read is made against the cluster. In the
+   * watched mode, reads of keys which are being watched are served from the cache, which
+   * the watch keeps current, and other reads fall back to the cluster.
+   */
+  Consistency   Consistency
+  
+  /**
+   * In the watched mode, how long the cache may continue to serve a key after its watch
+   * starts failing. By default, the cache is only used while the watch is healthy.
+   */
+  MaxStaleness  time.Duration
+  
+  /**
+   * A file to which the cache is persisted. If this is provided, the cache is loaded from
+   * the file when the configuration is created and written back to it as it changes. If
+   * the cluster cannot be reached, reads are served from the last known state of the
+   * cache (see EtcdConfig.Stale) and watches continue from the last known index.
+   */
+  CacheFile     string
+  
+  /**
+   * Bounds on the cache. Entries which are not being watched are evicted, least recently
+   * used first, once the cache holds more than the maximum number of entries or once they
+   * have not been used for longer than the maximum age. Entries which are being watched
+   * are never evicted. By default the cache is unbounded.
+   */
+  MaxCacheEntries int
+  MaxCacheAge   time.Duration
+  
+  /**
+   * Prefixes under which watches are multiplexed. Rather than watching each key under one
+   * of these prefixes individually, a single recursive watch is made on the prefix and its
+   * events are delivered to the observers of each key. This greatly reduces the number of
+   * connections made to the cluster when many keys under a common prefix are watched.
+   */
+  WatchPrefixes []string
+  
+  /**
+   * A PEM-encoded bundle of CA certificates used to verify the cluster members. If this
+   * is not provided the system roots are used.
+   */
+  CAFile        string
+  
+  /**
+   * A PEM-encoded client certificate and its key, which are presented to the cluster
+   * members for mutual TLS.
+   */
+  CertFile      string
+  KeyFile       string
+  
+  /**
+   * A TLS configuration on which to base our own. The CA bundle and client certificate,
+   * if any, are added to a copy of this configuration.
+   */
+  TLSConfig     *tls.Config
+  
+  /**
+   * Credentials for etcd basic authentication
+   */
+  Username      string
+  Password      string
+  
+  /**
+   * The policy which determines how failed requests are retried. If this is not provided
+   * requests are retried a few times with exponential backoff.
+   */
+  Retry         RetryPolicy
+  
+  /**
+   * The policy which determines how watches are retried when they fail. If the policy
+   * gives up, the watch stops. If this is not provided watches are retried indefinitely
+   * with exponential backoff.
+   */
+  WatchRetry    RetryPolicy
+  
+  /**
+   * The logger to which diagnostic messages are written. If this is not provided, the
+   * standard logger is used and requests are not logged.
+   */
+  Logger        Logger
+  
+  /**
+   * The sink to which metrics are reported. If this is not provided, metrics are not
+   * collected.
+   */
+  Metrics       Metrics
+  
+  /**
+   * Keys under these prefixes are considered sensitive; their values are redacted when
+   * requests are logged.
+   */
+  SensitivePrefixes []string
+  
+  /**
+   * The transport used to make requests. If this is provided, the TLS options above are
+   * ignored; the transport is expected to be configured as necessary.
+   */
+  Transport     http.RoundTripper
+  
+  /**
+   * The client used to make requests. If this is provided, the transport and TLS options
+   * above are ignored.
+   */
+  Client        *http.Client
+  
+}
+
+/**
+ * Create the HTTP client described by these options
+ */
+func (o *EtcdOptions) httpClient() (*http.Client, error) {
+  if o.Client != nil {
+    return o.Client, nil
+  }else if o.Transport != nil {
+    return &http.Client{Transport:o.Transport}, nil
+  }
+  
+  if o.CAFile == "" && o.CertFile == "" && o.TLSConfig == nil {
+    return httpClient, nil
+  }
+  
+  var conf *tls.Config
+  if o.TLSConfig != nil {
+    conf = o.TLSConfig.Clone()
+  }else{
+    conf = &tls.Config{}
+  }
+  
+  if o.CAFile != "" {
+    data, err := ioutil.ReadFile(o.CAFile)
+    if err != nil {
+      return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+      return nil, InvalidCAError
+    }
+    conf.RootCAs = pool
+  }
+  
+  if o.CertFile != "" || o.KeyFile != "" {
+    cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+    if err != nil {
+      return nil, err
+    }
+    conf.Certificates = append(conf.Certificates, cert)
+  }
+  
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  transport.TLSClientConfig = conf
+  
+  return &http.Client{Transport:transport}, nil
+}
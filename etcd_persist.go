@@ -0,0 +1,186 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "os"
+  "time"
+  "sync/atomic"
+  "path/filepath"
+  "encoding/json"
+)
+
+/**
+ * How long we wait after the cache changes before persisting it, so that a burst of
+ * changes results in a single write.
+ */
+const etcdPersistDelay = time.Second
+
+/**
+ * A persisted cache entry. The response index is not part of the response's JSON form
+ * so it is stored alongside.
+ */
+type etcdPersistedEntry struct {
+  Response    *etcdResponse     `json:"response"`
+  Index       int64             `json:"index"`
+}
+
+/**
+ * Load the persisted cache, if there is one
+ */
+func (c *etcdCache) load(path string) error {
+  
+  data, err := os.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  
+  entries := make(map[string]*etcdPersistedEntry)
+  err = json.Unmarshal(data, &entries)
+  if err != nil {
+    return err
+  }
+  
+  c.Lock()
+  defer c.Unlock()
+  for k, v := range entries {
+    if v.Response != nil {
+      v.Response.Index = v.Index
+      c.insert(newEtcdCacheEntry(k, v.Response))
+    }
+  }
+  
+  return nil
+}
+
+/**
+ * Persist the cache. The file is replaced atomically so a reader never sees a partial
+ * write.
+ */
+func (c *etcdCache) save(path string) error {
+  
+  c.RLock()
+  entries := make(map[string]*etcdPersistedEntry)
+  for k, e := range c.props {
+    if rsp := e.Response(); rsp != nil {
+      entries[k] = &etcdPersistedEntry{rsp, rsp.Index}
+    }
+  }
+  c.RUnlock()
+  
+  data, err := json.Marshal(entries)
+  if err != nil {
+    return err
+  }
+  
+  f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) +".*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name()) // no-op once it's been renamed
+  
+  _, err = f.Write(data)
+  if err == nil {
+    err = f.Sync()
+  }
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  if err != nil {
+    return err
+  }
+  
+  return os.Rename(f.Name(), path)
+}
+
+/**
+ * Note that the cache has changed and should be persisted
+ */
+func (c *etcdCache) changed() {
+  if c.dirty != nil {
+    select {
+      case c.dirty <- struct{}{}:
+      default: // a write is already pending
+    }
+  }
+}
+
+/**
+ * Persist the cache whenever it changes
+ */
+func (c *etcdCache) persist(path string) {
+  for range c.dirty {
+    <- time.After(etcdPersistDelay)
+    err := c.save(path)
+    if err != nil {
+      c.config.logger.Logf(LevelError, "Could not persist cache: %v", err)
+    }
+  }
+}
+
+/**
+ * Write the persisted cache immediately. This is useful when shutting down. If the cache
+ * is not persisted, this does nothing.
+ */
+func (e *EtcdConfig) FlushCache() error {
+  if e.cacheFile == "" {
+    return nil
+  }
+  return e.cache.save(e.cacheFile)
+}
+
+/**
+ * Determine if we are serving stale data. This is the case when the cluster cannot be
+ * reached and reads are being served from the last known state of the cache instead.
+ * Once a request to the cluster succeeds, we are no longer stale.
+ */
+func (e *EtcdConfig) Stale() bool {
+  return atomic.LoadInt32(&e.stale) != 0
+}
+
+/**
+ * Note whether we are serving stale data
+ */
+func (e *EtcdConfig) setStale(stale bool) {
+  var v int32
+  if stale {
+    v = 1
+  }
+  if atomic.SwapInt32(&e.stale, v) != v {
+    if stale {
+      e.logger.Logf(LevelWarn, "Cluster is unavailable; serving the last known state")
+    }else{
+      e.logger.Logf(LevelInfo, "Cluster is available again")
+    }
+  }
+}
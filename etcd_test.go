@@ -31,10 +31,17 @@
 package conf
 
 import (
+  "os"
   "fmt"
   "log"
+  "sync"
   "time"
+  "context"
   "testing"
+  "sync/atomic"
+  "net/http"
+  "net/http/httptest"
+  "encoding/pem"
 )
 
 func TestEtcdBasics(t *testing.T) {
@@ -153,3 +160,836 @@ func TestEtcdTimeout(t *testing.T) {
   }
   
 }
+
+func TestEtcdTTL(t *testing.T) {
+  
+  key := "test.ttl"
+  
+  e, err := NewEtcdConfig("http://localhost:4001/", time.Second * 3)
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  _, err = e.SetWithTTL(key, "Expires", time.Second)
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+    return
+  }
+  
+  err = e.RefreshTTL(key, time.Second)
+  if err != nil {
+    t.Errorf("Could not refresh: %v", err)
+  }
+  
+  v, err := e.Get(key)
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Expires" {
+    t.Errorf("Refresh should not change the value: %v", v)
+  }
+  
+  w := make(chan *Event)
+  e.WatchEvents(key, func(v *Event) {
+    w <- v
+  })
+  
+  select {
+    case v := <- w:
+      if v.Action != ActionExpire {
+        t.Errorf("Expected an expire event: %v", v.Action)
+      }
+    case <- time.After(time.Second * 5):
+      t.Errorf("Key should have expired")
+  }
+  
+}
+
+func TestEtcdMutex(t *testing.T) {
+  
+  e, err := NewEtcdConfig("http://localhost:4001/", time.Second * 3)
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  a := NewMutex(e, "test.lock", time.Second)
+  b := NewMutex(e, "test.lock", time.Second)
+  
+  err = a.Lock(context.Background())
+  if err != nil {
+    t.Errorf("Could not lock: %v", err)
+    return
+  }
+  
+  ctx, cancel := context.WithTimeout(context.Background(), time.Second * 2)
+  defer cancel()
+  
+  err = b.Lock(ctx)
+  if err != context.DeadlineExceeded {
+    t.Errorf("Lock should be held elsewhere: %v", err)
+  }
+  
+  err = a.Unlock(context.Background())
+  if err != nil {
+    t.Errorf("Could not unlock: %v", err)
+  }
+  
+  err = b.Lock(context.Background())
+  if err != nil {
+    t.Errorf("Could not lock: %v", err)
+  }
+  
+  err = b.Unlock(context.Background())
+  if err != nil {
+    t.Errorf("Could not unlock: %v", err)
+  }
+  
+}
+
+/**
+ * Create a server which grants locks and then, once it is partitioned, drops every
+ * connection without responding
+ */
+func newPartitionedServer(partitioned *int32) *httptest.Server {
+  return httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    if atomic.LoadInt32(partitioned) != 0 {
+      if conn, _, err := rsp.(http.Hijacker).Hijack(); err == nil {
+        conn.Close()
+      }
+      return
+    }
+    if req.Method == "PUT" {
+      rsp.WriteHeader(http.StatusCreated)
+    }
+    rsp.Write([]byte(`{"action":"set","node":{"key":"/test/lock","value":"Holder","modifiedIndex":1,"createdIndex":1}}`))
+  }))
+}
+
+func TestEtcdMutexPartition(t *testing.T) {
+  
+  var partitioned int32
+  s := newPartitionedServer(&partitioned)
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  m := NewMutex(e, "test.lock", time.Second)
+  start := time.Now()
+  err = m.Lock(context.Background())
+  if err != nil {
+    t.Errorf("Could not lock: %v", err)
+    return
+  }
+  
+  // we can't reach the cluster, so the lock has to be assumed lost once it would have
+  // expired
+  atomic.StoreInt32(&partitioned, 1)
+  select {
+    case <- m.Lost():
+      if d := time.Since(start); d < time.Second {
+        t.Errorf("Lock was lost before it expired: %v", d)
+      }
+    case <- time.After(time.Second * 3):
+      t.Errorf("Lock should have been lost")
+  }
+  
+  m.Unlock(context.Background())
+}
+
+func TestEtcdElection(t *testing.T) {
+  
+  e, err := NewEtcdConfig("http://localhost:4001/", time.Second * 3)
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  
+  a := NewElection(e, "test.leader", time.Second)
+  b := NewElection(e, "test.leader", time.Second)
+  
+  err = a.Campaign(ctx, "A")
+  if err != nil {
+    t.Errorf("Could not campaign: %v", err)
+    return
+  }
+  
+  leader, err := b.Leader()
+  if err != nil {
+    t.Errorf("Could not fetch leader: %v", err)
+  }else if leader != "A" {
+    t.Errorf("Unexpected leader: %v", leader)
+  }
+  
+  observe := b.Observe(ctx)
+  if l := <- observe; l != "A" {
+    t.Errorf("Unexpected leader: %v", l)
+  }
+  
+  err = a.Resign(ctx)
+  if err != nil {
+    t.Errorf("Could not resign: %v", err)
+  }
+  
+  if l := <- observe; l != "" {
+    t.Errorf("There should be no leader: %v", l)
+  }
+  
+  err = b.Campaign(ctx, "B")
+  if err != nil {
+    t.Errorf("Could not campaign: %v", err)
+  }
+  
+  if l := <- observe; l != "B" {
+    t.Errorf("Unexpected leader: %v", l)
+  }
+  
+  err = b.Resign(ctx)
+  if err != nil {
+    t.Errorf("Could not resign: %v", err)
+  }
+  
+}
+
+func TestEtcdElectionPartition(t *testing.T) {
+  
+  var partitioned int32
+  s := newPartitionedServer(&partitioned)
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  
+  a := NewElection(e, "test.leader", time.Second)
+  err = a.Campaign(ctx, "A")
+  if err != nil {
+    t.Errorf("Could not campaign: %v", err)
+    return
+  }
+  
+  // once we lose contact with the cluster for longer than the TTL another candidate may
+  // have been elected, so we must stop acting as the leader
+  atomic.StoreInt32(&partitioned, 1)
+  select {
+    case <- a.Lost():
+    case <- time.After(time.Second * 3):
+      t.Errorf("Leadership should have been lost")
+      return
+  }
+  
+  if err := a.Campaign(ctx, "A"); err == nil {
+    t.Errorf("Should not be elected while partitioned")
+  }
+  
+  // once contact is restored we can campaign again
+  atomic.StoreInt32(&partitioned, 0)
+  if err := a.Campaign(ctx, "A"); err != nil {
+    t.Errorf("Could not campaign: %v", err)
+  }
+  if err := a.Resign(ctx); err != nil {
+    t.Errorf("Could not resign: %v", err)
+  }
+  
+}
+
+func TestEtcdConcurrentCampaign(t *testing.T) {
+  
+  var once sync.Once
+  release := make(chan struct{})
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    if req.Method == "PUT" {
+      once.Do(func(){ <- release }) // hold the first campaign until we're done with it
+      rsp.WriteHeader(http.StatusCreated)
+    }
+    rsp.Write([]byte(`{"action":"create","node":{"key":"/test/leader","value":"A","modifiedIndex":1,"createdIndex":1}}`))
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  
+  a := NewElection(e, "test.leader", time.Second)
+  done := make(chan error)
+  go func(){
+    done <- a.Campaign(ctx, "A")
+  }()
+  
+  // wait for the first campaign to be underway
+  for i := 0; ; i++ {
+    a.state.Lock()
+    c := a.campaigning
+    a.state.Unlock()
+    if c {
+      break
+    }else if i > 100 {
+      t.Errorf("Campaign did not begin")
+      return
+    }
+    <- time.After(time.Millisecond * 10)
+  }
+  
+  if err := a.Campaign(ctx, "A"); err != CampaignInProgressError {
+    t.Errorf("Expected a campaign in progress: %v", err)
+  }
+  if l := a.Lost(); l != nil {
+    t.Errorf("We are not the leader yet")
+  }
+  
+  close(release)
+  if err := <- done; err != nil {
+    t.Errorf("Could not campaign: %v", err)
+  }
+  if err := a.Campaign(ctx, "A"); err != LockHeldError {
+    t.Errorf("Expected to be the leader already: %v", err)
+  }
+  if err := a.Resign(ctx); err != nil {
+    t.Errorf("Could not resign: %v", err)
+  }
+  
+}
+
+func TestEtcdRegistry(t *testing.T) {
+  
+  e, err := NewEtcdConfig("http://localhost:4001/", time.Second * 3)
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  r := NewRegistry(e, "test.services", time.Second)
+  
+  set, err := r.Watch("web")
+  if err != nil {
+    t.Errorf("Could not watch: %v", err)
+    return
+  }
+  
+  g, err := r.Register(context.Background(), "web", "a", "localhost:8080")
+  if err != nil {
+    t.Errorf("Could not register: %v", err)
+    return
+  }
+  
+  instances, err := r.Instances("web")
+  if err != nil {
+    t.Errorf("Could not list instances: %v", err)
+  }else if len(instances) != 1 || instances[0].Id != "a" || instances[0].Value != "localhost:8080" {
+    t.Errorf("Unexpected instances: %v", instances)
+  }
+  
+  <- time.After(time.Second * 2)
+  
+  if v, ok := set.Next(); !ok || v.Id != "a" {
+    t.Errorf("Unexpected instance: %v", v)
+  }
+  
+  err = g.Deregister(context.Background())
+  if err != nil {
+    t.Errorf("Could not deregister: %v", err)
+  }
+  
+  <- time.After(time.Second)
+  
+  if v, ok := set.Next(); ok {
+    t.Errorf("Instance should have been removed: %v", v)
+  }
+  
+}
+
+func TestEtcdFailover(t *testing.T) {
+  
+  key := "test.a.b.c"
+  
+  e, err := NewEtcdClusterConfig([]string{"http://localhost:1/", "http://localhost:4001/"}, time.Second * 3)
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  _, err = e.Set(key, "The value (failover)")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  
+  if v := e.Endpoints(); v[0] != "http://localhost:4001/" {
+    t.Errorf("Healthy member should be preferred: %v", v)
+  }
+  
+  err = e.SyncMembers(context.Background())
+  if err != nil {
+    t.Errorf("Could not sync members: %v", err)
+  }
+  
+  v, err := e.Get(key)
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else{
+    t.Logf("%v -> %v", key, v)
+  }
+  
+}
+
+func TestEtcdHungMember(t *testing.T) {
+  
+  var hung int32
+  release := make(chan struct{})
+  
+  h := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    atomic.AddInt32(&hung, 1)
+    select {
+      case <- req.Context().Done():
+      case <- release:
+    }
+  }))
+  defer h.Close()
+  defer close(release)
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    rsp.Write([]byte(`{"action":"get","node":{"key":"/test","value":"Healthy","modifiedIndex":1,"createdIndex":1}}`))
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{h.URL, s.URL}, &EtcdOptions{Timeout:time.Millisecond * 100, Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  for i := 0; i < 3; i++ {
+    v, err := e.Get("test")
+    if err != nil {
+      t.Errorf("Could not fetch: %v", err)
+    }else if v != "Healthy" {
+      t.Errorf("Unexpected value: %v", v)
+    }
+  }
+  
+  if v := e.Endpoints(); v[0] != s.URL {
+    t.Errorf("Healthy member should be preferred: %v", v)
+  }
+  if n := atomic.LoadInt32(&hung); n != 1 {
+    t.Errorf("Hung member should have been tried once: %v", n)
+  }
+  
+}
+
+func TestEtcdOptions(t *testing.T) {
+  
+  s := httptest.NewTLSServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "secret" {
+      rsp.WriteHeader(http.StatusUnauthorized)
+      rsp.Write([]byte(`{"errorCode":110,"message":"The request requires user authentication"}`))
+    }else{
+      rsp.Write([]byte(`{"action":"get","node":{"key":"/test","value":"Secure","modifiedIndex":1,"createdIndex":1}}`))
+    }
+  }))
+  defer s.Close()
+  
+  ca, err := os.CreateTemp("", "ca-*.pem")
+  if err != nil {
+    t.Errorf("Could not create CA bundle: %v", err)
+    return
+  }
+  defer os.Remove(ca.Name())
+  pem.Encode(ca, &pem.Block{Type:"CERTIFICATE", Bytes:s.Certificate().Raw})
+  ca.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{CAFile:ca.Name(), Username:"user", Password:"secret"})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  v, err := e.Get("test")
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Secure" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  e, err = NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{CAFile:ca.Name()})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  _, err = e.Get("test")
+  if err == nil {
+    t.Errorf("Request should not be authorized")
+  }
+  
+  e, err = NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Client:s.Client(), Username:"user", Password:"secret"})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  _, err = e.Get("test")
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }
+  
+}
+
+func TestEtcdCachedReads(t *testing.T) {
+  
+  key := "test.cached"
+  
+  e, err := NewEtcdConfigWithOptions([]string{"http://localhost:4001/"}, &EtcdOptions{Timeout:time.Second * 3, Consistency:ConsistencyWatched})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  _, err = e.Set(key, "Cached")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+    return
+  }
+  
+  w := make(chan struct{}, 1)
+  e.Watch(key, func(key string, val interface{}) {
+    w <- struct{}{}
+  })
+  
+  v, err := e.Get(key)
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Cached" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  _, err = e.Set(key, "Updated")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  
+  <- w
+  
+  v, err = e.Get(key)
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Updated" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+}
+
+func TestEtcdPersistedCache(t *testing.T) {
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    rsp.Header().Set("X-Etcd-Index", "7")
+    rsp.Write([]byte(`{"action":"get","node":{"key":"/test","value":"Persisted","modifiedIndex":5,"createdIndex":5}}`))
+  }))
+  
+  dir, err := os.MkdirTemp("", "cache-")
+  if err != nil {
+    t.Errorf("Could not create directory: %v", err)
+    return
+  }
+  defer os.RemoveAll(dir)
+  path := dir +"/cache.json"
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{CacheFile:path})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  v, err := e.Get("test")
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Persisted" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if e.Stale() {
+    t.Errorf("Should not be stale")
+  }
+  
+  err = e.FlushCache()
+  if err != nil {
+    t.Errorf("Could not persist cache: %v", err)
+    return
+  }
+  
+  // the cluster is now unreachable
+  s.Close()
+  
+  e, err = NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{CacheFile:path, Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  v, x, err := e.GetWithIndex("test")
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "Persisted" {
+    t.Errorf("Unexpected value: %v", v)
+  }else if x != 5 {
+    t.Errorf("Unexpected index: %v", x)
+  }
+  if !e.Stale() {
+    t.Errorf("Should be stale")
+  }
+  
+  rsp, _ := e.cache.Get("test")
+  if rsp == nil || rsp.Index != 7 {
+    t.Errorf("Index was not persisted: %v", rsp)
+  }
+  
+  _, err = e.Get("another")
+  if err == nil {
+    t.Errorf("Expected an error for a key that was never cached")
+  }
+  
+}
+
+func TestEtcdCacheBounds(t *testing.T) {
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    action := "get"
+    if req.Method == "DELETE" {
+      action = "delete"
+    }
+    rsp.Header().Set("X-Etcd-Index", "1")
+    fmt.Fprintf(rsp, `{"action":%q,"node":{"key":%q,"value":"Value","modifiedIndex":1,"createdIndex":1}}`, action, req.URL.Path[len("/v2/keys"):])
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{MaxCacheEntries:3})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  for _, k := range []string{"a", "b", "c", "a", "d", "e"} {
+    _, err = e.Get(k)
+    if err != nil {
+      t.Errorf("Could not fetch: %v", err)
+      return
+    }
+  }
+  
+  stats := e.CacheStats()
+  if stats.Entries != 3 {
+    t.Errorf("Unexpected number of entries: %v", stats.Entries)
+  }
+  if stats.Evictions != 2 {
+    t.Errorf("Unexpected number of evictions: %v", stats.Evictions)
+  }
+  if stats.Bytes <= 0 {
+    t.Errorf("Unexpected memory use: %v", stats.Bytes)
+  }
+  for _, k := range []string{"a", "d", "e"} {
+    if _, ok := e.cache.Get(k); !ok {
+      t.Errorf("Expected key to be cached: %v", k)
+    }
+  }
+  
+  err = e.Delete("a")
+  if err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }
+  if _, ok := e.cache.Get("a"); ok {
+    t.Errorf("Expected deleted key not to be cached")
+  }
+  
+  x, err := e.DeleteWithIndex("d")
+  if err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }else if x != 1 {
+    t.Errorf("Unexpected index: %v", x)
+  }
+  
+}
+
+func TestEtcdWatchOrdering(t *testing.T) {
+  
+  var watched int32
+  release := make(chan struct{})
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    switch {
+      case req.Method == "PUT":
+        rsp.Write([]byte(`{"action":"set","node":{"key":"/test","value":"New","modifiedIndex":10,"createdIndex":10}}`))
+      case req.URL.Query().Get("wait") == "true" && atomic.AddInt32(&watched, 1) == 1:
+        <- release // an event which happened before our write, delivered after it
+        rsp.Write([]byte(`{"action":"set","node":{"key":"/test","value":"Old","modifiedIndex":8,"createdIndex":8}}`))
+      default:
+        <- req.Context().Done()
+    }
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Consistency:ConsistencyWatched, Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  w := make(chan *Event, 1)
+  e.WatchEvents("test", func(v *Event) {
+    w <- v
+  })
+  
+  _, err = e.Set("test", "New")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  
+  close(release)
+  select {
+    case v := <- w:
+      if v.Value != "Old" {
+        t.Errorf("Unexpected event: %+v", v)
+      }
+    case <- time.After(time.Second):
+      t.Errorf("Timed out waiting for event")
+      return
+  }
+  
+  v, err := e.Get("test")
+  if err != nil {
+    t.Errorf("Could not fetch: %v", err)
+  }else if v != "New" {
+    t.Errorf("Older event should not replace a later state: %v", v)
+  }
+  
+}
+
+func TestEtcdValues(t *testing.T) {
+  
+  var requests int32
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    atomic.AddInt32(&requests, 1)
+    if req.URL.Query().Get("recursive") != "true" {
+      rsp.WriteHeader(http.StatusBadRequest)
+      return
+    }
+    rsp.Write([]byte(`{"action":"get","node":{"key":"/svc","dir":true,"nodes":[{"key":"/svc/host","value":"etcd.local","modifiedIndex":2},{"key":"/svc/db","dir":true,"nodes":[{"key":"/svc/db/port","value":"5432","modifiedIndex":3}]}]}}`))
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Retry:NoRetry})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  d, err := Diff(e, NewMemoryConfig(map[string]interface{}{"svc.host": "memory.local", "svc.db.port": 5432}), "svc")
+  if err != nil {
+    t.Errorf("Could not diff: %v", err)
+  }else if s := d.String(); s != "~ svc.host: etcd.local -> memory.local\n" {
+    t.Errorf("Unexpected description: %q", s)
+  }
+  if n := atomic.LoadInt32(&requests); n != 1 {
+    t.Errorf("Values should be read by a single request: %v", n)
+  }
+  
+}
+
+func TestEtcdMultiplexedWatch(t *testing.T) {
+  
+  e, err := NewEtcdConfigWithOptions([]string{"http://localhost:4001/"}, &EtcdOptions{Timeout:time.Second * 3, WatchPrefixes:[]string{"test.multiplexed"}})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  w := make(chan string, 2)
+  for _, k := range []string{"test.multiplexed.a", "test.multiplexed.b"} {
+    e.Watch(k, func(key string, val interface{}) {
+      w <- fmt.Sprintf("%s=%v", key, val)
+    })
+  }
+  
+  time.Sleep(time.Millisecond * 100)
+  
+  _, err = e.Set("test.multiplexed.b", "B")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v := <- w; v != "test.multiplexed.b=B" {
+    t.Errorf("Unexpected event: %v", v)
+  }
+  
+  _, err = e.Set("test.multiplexed.a", "A")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v := <- w; v != "test.multiplexed.a=A" {
+    t.Errorf("Unexpected event: %v", v)
+  }
+  
+  e.cache.RLock()
+  n := len(e.cache.prefixes)
+  e.cache.RUnlock()
+  if n != 1 {
+    t.Errorf("Expected a single multiplexed watch: %v", n)
+  }
+  
+}
+
+func TestEtcdResync(t *testing.T) {
+  
+  s := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    if req.URL.Query().Get("wait") != "true" {
+      rsp.Header().Set("X-Etcd-Index", "50")
+      rsp.Write([]byte(`{"action":"get","node":{"key":"/test","value":"Current","modifiedIndex":40,"createdIndex":40}}`))
+    }else if req.URL.Query().Get("waitIndex") == "51" {
+      rsp.Header().Set("X-Etcd-Index", "51")
+      rsp.Write([]byte(`{"action":"set","node":{"key":"/test","value":"Updated","modifiedIndex":51,"createdIndex":51}}`))
+    }else if req.URL.Query().Get("waitIndex") != "" {
+      rsp.WriteHeader(http.StatusBadRequest)
+      rsp.Write([]byte(`{"errorCode":401,"message":"The event in requested index is outdated and cleared"}`))
+    }else{
+      <- req.Context().Done()
+    }
+  }))
+  defer s.Close()
+  
+  e, err := NewEtcdConfigWithOptions([]string{s.URL}, &EtcdOptions{Logger:NewStdLogger(LevelError)})
+  if err != nil {
+    t.Errorf("Could create config: %v", err)
+    return
+  }
+  
+  // the watch continues from a state which the cluster no longer has history for
+  e.cache.Set("test", &etcdResponse{Action:"get", Node:&etcdNode{Key:"/test", Encoded:"Outdated", Modified:1}, Index:1})
+  
+  w := make(chan *Event, 2)
+  e.WatchEvents("test", func(v *Event) {
+    w <- v
+  })
+  
+  v := <- w
+  if v.Action != ActionResync || v.Value != "Current" || v.Index != 50 {
+    t.Errorf("Unexpected event: %+v", v)
+  }
+  v = <- w
+  if v.Action != ActionSet || v.Value != "Updated" {
+    t.Errorf("Unexpected event: %+v", v)
+  }
+  
+}
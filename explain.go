@@ -0,0 +1,218 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "fmt"
+  "strings"
+)
+
+/**
+ * Where a configuration value is defined. Which details are available depends on the
+ * configuration the value comes from.
+ */
+type Location struct {
+  File      string  // the file which defines the value
+  Line      int     // the line in the file
+  Variable  string  // the environment variable which defines the value
+  Index     int64   // the modification index of the value (e.g., in etcd)
+}
+
+/**
+ * Describe a location
+ */
+func (l *Location) String() string {
+  if l == nil {
+    return "unknown"
+  }
+  parts := make([]string, 0, 3)
+  if l.File != "" {
+    if l.Line > 0 {
+      parts = append(parts, fmt.Sprintf("%s:%d", l.File, l.Line))
+    }else{
+      parts = append(parts, l.File)
+    }
+  }
+  if l.Variable != "" {
+    parts = append(parts, "$"+ l.Variable)
+  }
+  if l.Index > 0 {
+    parts = append(parts, fmt.Sprintf("index %d", l.Index))
+  }
+  if len(parts) < 1 {
+    return "unknown"
+  }
+  return strings.Join(parts, ", ")
+}
+
+/**
+ * Implemented by configurations which can describe where their values are defined. If
+ * the location of a key is not known, a nil location is returned.
+ */
+type Locator interface {
+  Locate(key string) (*Location, error)
+}
+
+/**
+ * What a layer of a suite supplies for a key
+ */
+type LayerValue struct {
+  Layer     string      // the name of the layer
+  Index     int         // the position of the layer in the suite, from highest priority
+  Found     bool        // whether the layer defines the key
+  Value     interface{} // the value the layer supplies
+  Location  *Location   // where the layer's value is defined, if known
+  Err       error       // the error produced by the layer, if any
+}
+
+/**
+ * An explanation of where the value of a key comes from
+ */
+type Explanation struct {
+  Key       string
+  Value     interface{}   // the effective value of the key
+  Layer     string        // the name of the highest priority layer which defines the key
+  Merged    bool          // whether the value was merged from several layers
+  Layers    []*LayerValue // what every layer supplies, in order of priority
+}
+
+/**
+ * Describe an explanation
+ */
+func (e *Explanation) String() string {
+  b := &strings.Builder{}
+  if !e.found() {
+    fmt.Fprintf(b, "%s: not defined\n", e.Key)
+  }else if e.Merged {
+    fmt.Fprintf(b, "%s = %v (merged)\n", e.Key, e.Value)
+  }else{
+    fmt.Fprintf(b, "%s = %v\n", e.Key, e.Value)
+  }
+  for _, l := range e.Layers {
+    name := l.Layer
+    if name == "" {
+      name = fmt.Sprintf("#%d", l.Index)
+    }
+    switch {
+      case l.Err != nil:
+        fmt.Fprintf(b, "  %s: error: %v\n", name, l.Err)
+      case !l.Found:
+        fmt.Fprintf(b, "  %s: not defined\n", name)
+      default:
+        fmt.Fprintf(b, "  %s: %v (%v)\n", name, l.Value, l.Location)
+    }
+  }
+  return b.String()
+}
+
+/**
+ * Determine if any layer defines the key
+ */
+func (e *Explanation) found() bool {
+  for _, l := range e.Layers {
+    if l.Found {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Explain where the value of a key comes from: which layer supplies it and what every
+ * other layer would supply. Layers which implement Locator also describe where their
+ * value is defined.
+ * 
+ * A layer which fails to provide its value is described by its error and the value is
+ * derived from the other layers, even though Get would fail.
+ */
+func (s *ConfigSuite) Explain(key string) (*Explanation, error) {
+  x := &Explanation{Key:key, Layers:make([]*LayerValue, len(s.suite))}
+  
+  var found int
+  for i, l := range s.suite {
+    v := &LayerValue{Layer:l.Name, Index:i}
+    x.Layers[i] = v
+    
+    val, err := l.Config.Get(key)
+    if err == NoSuchKeyError {
+      continue
+    }else if err != nil {
+      v.Err = err
+      continue
+    }
+    
+    v.Found, v.Value = true, val
+    if found == 0 {
+      x.Layer = l.Name
+    }
+    found++
+    
+    if c, ok := l.Config.(Locator); ok {
+      v.Location, v.Err = c.Locate(key)
+    }
+  }
+  
+  // derive the value from what the layers supplied, as Get would
+  var merged bool
+  for i := len(x.Layers) - 1; i >= 0; i-- {
+    if v := x.Layers[i]; !v.Found {
+      continue
+    }else if s.merge != nil && merged {
+      x.Value = s.merge.merge(x.Value, v.Value)
+    }else{
+      x.Value, merged = v.Value, true
+    }
+  }
+  x.Merged = s.merge != nil && found > 1
+  
+  return x, nil
+}
+
+/**
+ * Describe where a key is defined: the location provided by the highest priority layer
+ * which defines it.
+ */
+func (s *ConfigSuite) Locate(key string) (*Location, error) {
+  for _, l := range s.suite {
+    _, err := l.Config.Get(key)
+    if err == NoSuchKeyError {
+      continue
+    }else if err != nil {
+      return nil, err
+    }
+    if c, ok := l.Config.(Locator); ok {
+      return c.Locate(key)
+    }
+    return nil, nil
+  }
+  return nil, NoSuchKeyError
+}
+
@@ -0,0 +1,105 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+type testLocator struct {
+  *MemoryConfig
+  variable string
+}
+
+func (c testLocator) Locate(key string) (*Location, error) {
+  return &Location{Variable:c.variable}, nil
+}
+
+func TestExplain(t *testing.T) {
+  
+  runtime := NewMemoryConfig(nil)
+  env := testLocator{NewMemoryConfig(map[string]interface{}{"db.host": "env.local"}), "DB_HOST"}
+  file := NewMemoryConfig(map[string]interface{}{"db.host": "file.local"})
+  s := NewConfigSuiteWithLayers(Layer{Name:"runtime", Config:runtime}, Layer{Name:"env", Config:env}, Layer{Name:"file", Config:ReadOnly(file)})
+  
+  x, err := s.Explain("db.host")
+  if err != nil {
+    t.Errorf("Could not explain: %v", err)
+    return
+  }
+  if x.Value != "env.local" || x.Layer != "env" || x.Merged {
+    t.Errorf("Unexpected explanation: %+v", x)
+  }
+  if len(x.Layers) != 3 {
+    t.Errorf("Unexpected layers: %v", x.Layers)
+    return
+  }
+  if l := x.Layers[0]; l.Found {
+    t.Errorf("Unexpected layer: %+v", l)
+  }
+  if l := x.Layers[1]; !l.Found || l.Value != "env.local" || l.Location.String() != "$DB_HOST" {
+    t.Errorf("Unexpected layer: %+v", l)
+  }
+  if l := x.Layers[2]; !l.Found || l.Value != "file.local" || l.Location == nil || l.Location.Index != 1 {
+    t.Errorf("Unexpected layer: %+v", l)
+  }
+  if d := x.String(); !strings.Contains(d, "env: env.local ($DB_HOST)") || !strings.Contains(d, "runtime: not defined") {
+    t.Errorf("Unexpected description: %v", d)
+  }
+  
+  l, err := s.Locate("db.host")
+  if err != nil || l.Variable != "DB_HOST" {
+    t.Errorf("Unexpected location: %v, %v", l, err)
+  }
+  
+  // a layer which fails is described, rather than failing the explanation
+  broken := Wrap(NewMemoryConfig(nil), func(op *Operation, next Invoker) (interface{}, error) {
+    return nil, errors.New("Unavailable")
+  })
+  f := NewConfigSuiteWithLayers(Layer{Name:"broken", Config:broken}, Layer{Name:"file", Config:file})
+  x, err = f.Explain("db.host")
+  if err != nil {
+    t.Errorf("Could not explain: %v", err)
+  }else if x.Value != "file.local" || x.Layer != "file" || x.Layers[0].Err == nil {
+    t.Errorf("Unexpected explanation: %+v", x)
+  }
+  
+  x, err = s.Explain("missing")
+  if err != nil {
+    t.Errorf("Could not explain: %v", err)
+  }else if x.Layer != "" || x.Value != nil {
+    t.Errorf("Unexpected explanation: %+v", x)
+  }
+  
+}
+
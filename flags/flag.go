@@ -0,0 +1,365 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package flags
+
+import (
+  "fmt"
+  "math"
+  "strings"
+  "hash/fnv"
+  "encoding/binary"
+  
+  "github.com/bww/go-conf"
+)
+
+/**
+ * Variations of boolean flags
+ */
+const (
+  On  = "on"
+  Off = "off"
+)
+
+/**
+ * Clause operators
+ */
+const (
+  OpIn          = "in"
+  OpNotIn       = "notIn"
+  OpStartsWith  = "startsWith"
+  OpEndsWith    = "endsWith"
+  OpContains    = "contains"
+  OpLessThan    = "lessThan"
+  OpGreaterThan = "greaterThan"
+)
+
+/**
+ * A flag definition. A flag serves one of its variations to each user it is evaluated
+ * for. If the flag is disabled, the off variation is served to everyone. Otherwise, the
+ * first rule which matches the user determines the variation; if no rule matches, the
+ * default variation or rollout applies.
+ * 
+ * A flag which defines no variations is boolean; its variations are "on" (true) and
+ * "off" (false).
+ */
+type Flag struct {
+  Enabled     bool                    `json:"enabled"`
+  Variations  map[string]interface{}  `json:"variations,omitempty"`
+  Off         string                  `json:"off,omitempty"`
+  Default     string                  `json:"default,omitempty"`
+  Rollout     *Rollout                `json:"rollout,omitempty"`
+  Rules       []*Rule                 `json:"rules,omitempty"`
+}
+
+/**
+ * A targeting rule. A rule matches a user if all of its clauses match. The variation it
+ * serves is either fixed or determined by a rollout.
+ */
+type Rule struct {
+  Clauses     []*Clause               `json:"clauses"`
+  Variation   string                  `json:"variation,omitempty"`
+  Rollout     *Rollout                `json:"rollout,omitempty"`
+}
+
+/**
+ * A condition on a user attribute. The attribute "key" refers to the user's key.
+ */
+type Clause struct {
+  Attribute   string                  `json:"attribute"`
+  Op          string                  `json:"op"`
+  Values      []interface{}           `json:"values"`
+}
+
+/**
+ * A percentage rollout. Users are assigned to a bucket by hashing the bucketing attribute
+ * (by default, their key), so a user consistently receives the same variation as long
+ * as the weights don't change.
+ */
+type Rollout struct {
+  Attribute   string                  `json:"attribute,omitempty"`
+  Weights     []*Weight               `json:"weights"`
+}
+
+/**
+ * The percentage of users who receive a variation
+ */
+type Weight struct {
+  Variation   string                  `json:"variation"`
+  Percent     float64                 `json:"percent"`
+}
+
+/**
+ * A user for whom flags are evaluated
+ */
+type User struct {
+  Key         string
+  Attributes  map[string]interface{}
+}
+
+/**
+ * Obtain an attribute
+ */
+func (u *User) Attribute(name string) (interface{}, bool) {
+  if u == nil {
+    return nil, false
+  }else if name == "key" {
+    return u.Key, u.Key != ""
+  }
+  v, ok := u.Attributes[name]
+  return v, ok
+}
+
+/**
+ * Obtain the variations of this flag
+ */
+func (f *Flag) variations() map[string]interface{} {
+  if len(f.Variations) > 0 {
+    return f.Variations
+  }
+  return map[string]interface{}{On: true, Off: false}
+}
+
+/**
+ * Obtain the variation served when the flag is disabled
+ */
+func (f *Flag) off() string {
+  if f.Off != "" {
+    return f.Off
+  }
+  return Off
+}
+
+/**
+ * Make sure this flag is well-formed
+ */
+func (f *Flag) Validate() error {
+  vars := f.variations()
+  check := func(v string) error {
+    if _, ok := vars[v]; !ok {
+      return fmt.Errorf("No such variation: %v", v)
+    }
+    return nil
+  }
+  
+  if err := check(f.off()); err != nil {
+    return err
+  }
+  if f.Rollout != nil {
+    if err := f.Rollout.validate(check); err != nil {
+      return err
+    }
+  }else if f.Default != "" {
+    if err := check(f.Default); err != nil {
+      return err
+    }
+  }
+  
+  for _, r := range f.Rules {
+    if r.Rollout != nil {
+      if err := r.Rollout.validate(check); err != nil {
+        return err
+      }
+    }else if err := check(r.Variation); err != nil {
+      return err
+    }
+    for _, c := range r.Clauses {
+      switch c.Op {
+        case OpIn, OpNotIn, OpStartsWith, OpEndsWith, OpContains, OpLessThan, OpGreaterThan:
+        default:
+          return fmt.Errorf("No such operator: %v", c.Op)
+      }
+    }
+  }
+  
+  return nil
+}
+
+/**
+ * Make sure this rollout is well-formed
+ */
+func (r *Rollout) validate(check func(string) error) error {
+  var total float64
+  for _, w := range r.Weights {
+    if err := check(w.Variation); err != nil {
+      return err
+    }
+    if w.Percent < 0 {
+      return fmt.Errorf("Invalid weight: %v", w.Percent)
+    }
+    total += w.Percent
+  }
+  // allow for floating point error, e.g., 0.1 + 64.1 + 35.8 != 100
+  if math.Abs(total - 100) > 1e-9 {
+    return fmt.Errorf("Rollout weights must add up to 100, not %v", total)
+  }
+  return nil
+}
+
+/**
+ * Evaluate this flag for a user. The variation served and its value are returned.
+ */
+func (f *Flag) Evaluate(name string, user *User) (string, interface{}) {
+  vars := f.variations()
+  if !f.Enabled {
+    return f.off(), vars[f.off()]
+  }
+  
+  v := f.Default
+  if f.Rollout != nil {
+    v = f.Rollout.variation(name, user)
+  }
+  
+  for _, r := range f.Rules {
+    if r.matches(user) {
+      if r.Rollout != nil {
+        v = r.Rollout.variation(name, user)
+      }else{
+        v = r.Variation
+      }
+      break
+    }
+  }
+  
+  if v == "" {
+    v = f.off()
+  }
+  return v, vars[v]
+}
+
+/**
+ * Determine if a rule matches a user
+ */
+func (r *Rule) matches(user *User) bool {
+  for _, c := range r.Clauses {
+    if !c.matches(user) {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * Determine if a clause matches a user. A user who lacks the attribute only matches a
+ * negated clause.
+ */
+func (c *Clause) matches(user *User) bool {
+  a, ok := user.Attribute(c.Attribute)
+  if !ok {
+    return c.Op == OpNotIn
+  }
+  
+  if c.Op == OpNotIn {
+    return !c.any(a, OpIn)
+  }
+  return c.any(a, c.Op)
+}
+
+/**
+ * Determine if an attribute matches any of our values
+ */
+func (c *Clause) any(a interface{}, op string) bool {
+  as, _ := conf.AsString(a)
+  for _, v := range c.Values {
+    vs, _ := conf.AsString(v)
+    switch op {
+      case OpIn:
+        if as == vs {
+          return true
+        }
+      case OpStartsWith:
+        if strings.HasPrefix(as, vs) {
+          return true
+        }
+      case OpEndsWith:
+        if strings.HasSuffix(as, vs) {
+          return true
+        }
+      case OpContains:
+        if strings.Contains(as, vs) {
+          return true
+        }
+      case OpLessThan, OpGreaterThan:
+        an, err := conf.AsFloat(a)
+        if err != nil {
+          return false
+        }
+        vn, err := conf.AsFloat(v)
+        if err != nil {
+          continue
+        }
+        if (op == OpLessThan && an < vn) || (op == OpGreaterThan && an > vn) {
+          return true
+        }
+    }
+  }
+  return false
+}
+
+/**
+ * Determine the variation a user receives from a rollout
+ */
+func (r *Rollout) variation(name string, user *User) string {
+  attr := r.Attribute
+  if attr == "" {
+    attr = "key"
+  }
+  
+  a, _ := user.Attribute(attr)
+  s, _ := conf.AsString(a)
+  b := bucket(name, s)
+  
+  var sum float64
+  for _, w := range r.Weights {
+    sum += w.Percent
+    if b < sum {
+      return w.Variation
+    }
+  }
+  if n := len(r.Weights); n > 0 {
+    return r.Weights[n - 1].Variation
+  }
+  return ""
+}
+
+/**
+ * Assign a value to a bucket in [0, 100). The flag name is included so that the same
+ * users don't always fall into the first percent of every rollout.
+ */
+func bucket(name, value string) float64 {
+  h := fnv.New64a()
+  h.Write([]byte(name))
+  h.Write([]byte{0})
+  h.Write([]byte(value))
+  var b [8]byte
+  n := binary.BigEndian.Uint64(h.Sum(b[:0]))
+  return float64(n % 10000) / 100
+}
+
@@ -0,0 +1,358 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package flags
+
+import (
+  "fmt"
+  "sync"
+  "errors"
+  "context"
+  "encoding/json"
+  
+  "github.com/bww/go-conf"
+)
+
+var NoSuchFlagError = errors.New("No such flag")
+var NoDefaultSetError = errors.New("No default flag set")
+
+/**
+ * Context key
+ */
+type contextKey int
+
+const userKey contextKey = 0
+
+/**
+ * Obtain a context which carries a user. Flags which are evaluated without a user are
+ * evaluated for the user in their context, if any.
+ */
+func NewContext(parent context.Context, user *User) context.Context {
+  return context.WithValue(parent, userKey, user)
+}
+
+/**
+ * Obtain the user carried by a context
+ */
+func FromContext(ctx context.Context) (*User, bool) {
+  u, ok := ctx.Value(userKey).(*User)
+  return u, ok
+}
+
+/**
+ * A set of flags whose definitions are stored in a configuration. Each flag is stored
+ * under the set's prefix as JSON (or, in configurations which support structured values,
+ * as a map). Flags are loaded when they are first evaluated and, if the configuration is
+ * Observable, kept current as their definitions change.
+ */
+type Set struct {
+  state     sync.RWMutex
+  reloading sync.Mutex
+  config    conf.Config
+  prefix    string
+  flags     map[string]*Flag
+  watched   map[string]struct{}
+  onError   []func(string, error)
+}
+
+/**
+ * Create a flag set
+ */
+func NewSet(config conf.Config, prefix string) *Set {
+  return &Set{config:config, prefix:prefix, flags:make(map[string]*Flag), watched:make(map[string]struct{})}
+}
+
+/**
+ * Obtain the key under which a flag is stored
+ */
+func (s *Set) key(name string) string {
+  if s.prefix == "" {
+    return name
+  }
+  return s.prefix +"."+ name
+}
+
+/**
+ * Add a function which is called when a flag definition cannot be loaded. When an
+ * updated definition is invalid, the previous definition remains in effect.
+ */
+func (s *Set) OnError(f func(string, error)) {
+  s.state.Lock()
+  defer s.state.Unlock()
+  s.onError = append(s.onError, f)
+}
+
+/**
+ * Store a flag definition
+ */
+func (s *Set) Define(name string, flag *Flag) error {
+  err := flag.Validate()
+  if err != nil {
+    return err
+  }
+  data, err := json.Marshal(flag)
+  if err != nil {
+    return err
+  }
+  _, err = s.config.Set(s.key(name), string(data))
+  if err != nil {
+    return err
+  }
+  s.state.Lock()
+  if _, ok := s.flags[name]; ok {
+    s.flags[name] = flag
+  }
+  s.state.Unlock()
+  return nil
+}
+
+/**
+ * Obtain a flag definition, loading it if necessary
+ */
+func (s *Set) Flag(name string) (*Flag, error) {
+  s.state.RLock()
+  f, ok := s.flags[name]
+  s.state.RUnlock()
+  if ok {
+    if f == nil {
+      return nil, NoSuchFlagError
+    }
+    return f, nil
+  }
+  
+  // the flag is loaded like a reload, so it can't replace a definition which was read
+  // later; its key is watched before it is read so that a change made in between isn't
+  // missed
+  s.reloading.Lock()
+  if _, ok := s.watched[name]; !ok {
+    s.watched[name] = struct{}{}
+    if o, ok := s.config.(conf.Observable); ok {
+      o.Watch(s.key(name), func(string, interface{}) {
+        s.reload(name)
+      })
+    }
+  }
+  
+  s.state.RLock()
+  f, ok = s.flags[name]
+  s.state.RUnlock()
+  if !ok {
+    var err error
+    f, err = s.load(name)
+    if err != nil && err != NoSuchFlagError {
+      s.reloading.Unlock()
+      return nil, err
+    }
+    s.state.Lock()
+    s.flags[name] = f // a missing flag is remembered as nil
+    s.state.Unlock()
+  }
+  s.reloading.Unlock()
+  
+  if f == nil {
+    return nil, NoSuchFlagError
+  }
+  return f, nil
+}
+
+/**
+ * Load a flag definition from the configuration
+ */
+func (s *Set) load(name string) (*Flag, error) {
+  v, err := s.config.Get(s.key(name))
+  if err == conf.NoSuchKeyError {
+    return nil, NoSuchFlagError
+  }else if err != nil {
+    return nil, err
+  }
+  
+  var data []byte
+  switch c := v.(type) {
+    case string:
+      data = []byte(c)
+    case []byte:
+      data = c
+    default:
+      data, err = json.Marshal(c)
+      if err != nil {
+        return nil, err
+      }
+  }
+  
+  f := &Flag{}
+  err = json.Unmarshal(data, f)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid flag definition: %v", err)
+  }
+  err = f.Validate()
+  if err != nil {
+    return nil, err
+  }
+  
+  return f, nil
+}
+
+/**
+ * Reload a flag definition after it changed. Reloads are serialized so that a definition
+ * which was read earlier can't replace one which was read later.
+ */
+func (s *Set) reload(name string) {
+  s.reloading.Lock()
+  f, err := s.load(name)
+  if err == nil || err == NoSuchFlagError {
+    s.state.Lock()
+    s.flags[name] = f
+    s.state.Unlock()
+    s.reloading.Unlock()
+    return
+  }
+  s.reloading.Unlock()
+  
+  s.state.RLock()
+  observers := make([]func(string, error), len(s.onError))
+  copy(observers, s.onError)
+  s.state.RUnlock()
+  
+  for _, o := range observers {
+    o(name, err)
+  }
+}
+
+/**
+ * Evaluate a flag for a user. If the user is nil, the user in the context is used. The
+ * variation served and its value are returned.
+ */
+func (s *Set) Evaluate(ctx context.Context, name string, user *User) (string, interface{}, error) {
+  f, err := s.Flag(name)
+  if err != nil {
+    return "", nil, err
+  }
+  if user == nil {
+    user, _ = FromContext(ctx)
+  }
+  v, val := f.Evaluate(name, user)
+  return v, val, nil
+}
+
+/**
+ * Evaluate a boolean flag. If the flag does not exist or is not boolean, false is
+ * returned.
+ */
+func (s *Set) Bool(ctx context.Context, name string, user *User) bool {
+  _, v, err := s.Evaluate(ctx, name, user)
+  if err != nil {
+    return false
+  }
+  b, err := conf.AsBool(v)
+  if err != nil {
+    return false
+  }
+  return b
+}
+
+/**
+ * Evaluate a flag and obtain its value as a string. If the flag does not exist, the
+ * provided default is returned.
+ */
+func (s *Set) String(ctx context.Context, name string, user *User, def string) string {
+  _, v, err := s.Evaluate(ctx, name, user)
+  if err != nil || v == nil {
+    return def
+  }
+  r, err := conf.AsString(v)
+  if err != nil {
+    return def
+  }
+  return r
+}
+
+/**
+ * Evaluate a flag and obtain the name of the variation served
+ */
+func (s *Set) Variation(ctx context.Context, name string, user *User) (string, error) {
+  v, _, err := s.Evaluate(ctx, name, user)
+  return v, err
+}
+
+var (
+  defaultLock sync.RWMutex
+  defaultSet  *Set
+)
+
+/**
+ * Set the flag set used by the package-level functions
+ */
+func SetDefault(s *Set) {
+  defaultLock.Lock()
+  defer defaultLock.Unlock()
+  defaultSet = s
+}
+
+/**
+ * Obtain the flag set used by the package-level functions
+ */
+func Default() *Set {
+  defaultLock.RLock()
+  defer defaultLock.RUnlock()
+  return defaultSet
+}
+
+/**
+ * Evaluate a boolean flag in the default set. If there is no default set, the flag
+ * does not exist or it is not boolean, false is returned.
+ */
+func Bool(ctx context.Context, name string, user *User) bool {
+  if s := Default(); s != nil {
+    return s.Bool(ctx, name, user)
+  }
+  return false
+}
+
+/**
+ * Evaluate a flag in the default set and obtain its value as a string. If there is no
+ * default set or the flag does not exist, the provided default is returned.
+ */
+func String(ctx context.Context, name string, user *User, def string) string {
+  if s := Default(); s != nil {
+    return s.String(ctx, name, user, def)
+  }
+  return def
+}
+
+/**
+ * Evaluate a flag in the default set and obtain the name of the variation served
+ */
+func Variation(ctx context.Context, name string, user *User) (string, error) {
+  if s := Default(); s != nil {
+    return s.Variation(ctx, name, user)
+  }
+  return "", NoDefaultSetError
+}
+
@@ -0,0 +1,236 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package flags
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "context"
+  "testing"
+  
+  "github.com/bww/go-conf"
+)
+
+func TestFlags(t *testing.T) {
+  ctx := context.Background()
+  
+  m := conf.NewMemoryConfig(map[string]interface{}{
+    "flags.checkout": `{"enabled":true,"default":"off","rules":[{"clauses":[{"attribute":"country","op":"in","values":["ca","us"]}],"variation":"on"}]}`,
+    "flags.theme": map[string]interface{}{
+      "enabled": true,
+      "variations": map[string]interface{}{"light":"#fff","dark":"#000"},
+      "default": "light",
+      "off": "light",
+      "rules": []interface{}{
+        map[string]interface{}{"clauses":[]interface{}{map[string]interface{}{"attribute":"key","op":"startsWith","values":[]interface{}{"admin-"}}}, "variation":"dark"},
+      },
+    },
+    "flags.broken": `{"enabled":true,"default":"nope"}`,
+  })
+  
+  s := NewSet(m, "flags")
+  SetDefault(s)
+  
+  if !Bool(ctx, "checkout", &User{Key:"a", Attributes:map[string]interface{}{"country":"ca"}}) {
+    t.Errorf("Expected flag to be on")
+  }
+  if Bool(ctx, "checkout", &User{Key:"b", Attributes:map[string]interface{}{"country":"fr"}}) {
+    t.Errorf("Expected flag to be off")
+  }
+  if Bool(ctx, "checkout", nil) {
+    t.Errorf("Expected flag to be off")
+  }
+  if !Bool(NewContext(ctx, &User{Key:"c", Attributes:map[string]interface{}{"country":"us"}}), "checkout", nil) {
+    t.Errorf("Expected flag to be on for the user in the context")
+  }
+  
+  if v := String(ctx, "theme", &User{Key:"admin-1"}, ""); v != "#000" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if v := String(ctx, "theme", &User{Key:"user-1"}, ""); v != "#fff" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if v := String(ctx, "missing", nil, "Default"); v != "Default" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  if _, err := Variation(ctx, "broken", nil); err == nil {
+    t.Errorf("Expected an invalid definition")
+  }
+  if _, err := Variation(ctx, "missing", nil); err != NoSuchFlagError {
+    t.Errorf("Expected no such flag: %v", err)
+  }
+  
+}
+
+func TestFlagRollout(t *testing.T) {
+  ctx := context.Background()
+  s := NewSet(conf.NewMemoryConfig(nil), "flags")
+  
+  err := s.Define("rollout", &Flag{Enabled:true, Rollout:&Rollout{Weights:[]*Weight{{On, 25}, {Off, 75}}}})
+  if err != nil {
+    t.Errorf("Could not define: %v", err)
+    return
+  }
+  
+  n := 0
+  for i := 0; i < 10000; i++ {
+    u := &User{Key:fmt.Sprintf("user-%d", i)}
+    a := s.Bool(ctx, "rollout", u)
+    if a {
+      n++
+    }
+    if s.Bool(ctx, "rollout", u) != a {
+      t.Errorf("Expected evaluation to be consistent for %v", u.Key)
+    }
+  }
+  if n < 2250 || n > 2750 {
+    t.Errorf("Unexpected number of users in rollout: %v", n)
+  }
+  
+  err = s.Define("invalid", &Flag{Enabled:true, Rollout:&Rollout{Weights:[]*Weight{{On, 25}}}})
+  if err == nil {
+    t.Errorf("Expected an invalid definition")
+  }
+  
+  // weights which don't sum to exactly 100 in floating point are still valid
+  variations := map[string]interface{}{"a":1, "b":2, "c":3}
+  err = s.Define("fractional", &Flag{Enabled:true, Variations:variations, Default:"a", Off:"a", Rollout:&Rollout{Weights:[]*Weight{{"a", 0.1}, {"b", 64.1}, {"c", 35.8}}}})
+  if err != nil {
+    t.Errorf("Could not define: %v", err)
+  }
+  
+}
+
+func TestFlagUpdates(t *testing.T) {
+  ctx := context.Background()
+  m := conf.NewMemoryConfig(nil)
+  s := NewSet(m, "flags")
+  
+  errs := make(chan error, 1)
+  s.OnError(func(name string, err error) {
+    errs <- err
+  })
+  
+  if s.Bool(ctx, "feature", nil) {
+    t.Errorf("Expected flag to be off")
+  }
+  
+  m.Set("flags.feature", `{"enabled":true,"default":"on"}`)
+  if !eventually(func() bool { return s.Bool(ctx, "feature", nil) }) {
+    t.Errorf("Expected flag to be on")
+  }
+  
+  // an invalid update leaves the flag as it was
+  m.Set("flags.feature", `{"enabled":true,"default":"nope"}`)
+  <- errs
+  if !s.Bool(ctx, "feature", nil) {
+    t.Errorf("Expected flag to be on")
+  }
+  
+  m.Set("flags.feature", `{"enabled":false,"default":"on"}`)
+  if !eventually(func() bool { return !s.Bool(ctx, "feature", nil) }) {
+    t.Errorf("Expected flag to be off")
+  }
+  
+  m.Delete("flags.feature")
+  if !eventually(func() bool { _, err := s.Flag("feature"); return err == NoSuchFlagError }) {
+    t.Errorf("Expected flag to be removed")
+  }
+  
+}
+
+func TestFlagReloadOrdering(t *testing.T) {
+  stale := `{"enabled":false,"default":"on"}`
+  m := conf.NewMemoryConfig(map[string]interface{}{"flags.feature": stale})
+  
+  // reading the stale definition is slow, so it finishes after a later read
+  c := conf.Wrap(m, func(op *conf.Operation, next conf.Invoker) (interface{}, error) {
+    v, err := next(op)
+    if op.Op == conf.OpGet && v == stale {
+      time.Sleep(time.Millisecond * 100)
+    }
+    return v, err
+  })
+  s := NewSet(c, "flags")
+  
+  done := make(chan struct{})
+  go func() {
+    s.reload("feature")
+    close(done)
+  }()
+  time.Sleep(time.Millisecond * 20)
+  m.Set("flags.feature", `{"enabled":true,"default":"on"}`)
+  s.reload("feature")
+  <- done
+  
+  f, err := s.Flag("feature")
+  if err != nil {
+    t.Errorf("Could not load: %v", err)
+  }else if !f.Enabled {
+    t.Errorf("Expected the later definition to win")
+  }
+  
+}
+
+func TestFlagInitialLoad(t *testing.T) {
+  ctx := context.Background()
+  m := conf.NewMemoryConfig(map[string]interface{}{"flags.feature": `{"enabled":false,"default":"on"}`})
+  
+  // a change made just after the flag is first read is not missed
+  var once sync.Once
+  c := conf.Wrap(m, func(op *conf.Operation, next conf.Invoker) (interface{}, error) {
+    v, err := next(op)
+    if op.Op == conf.OpGet && op.Key == "flags.feature" {
+      once.Do(func() { m.Set("flags.feature", `{"enabled":true,"default":"on"}`) })
+    }
+    return v, err
+  })
+  s := NewSet(c, "flags")
+  
+  if !eventually(func() bool { return s.Bool(ctx, "feature", nil) }) {
+    t.Errorf("Expected flag to be on")
+  }
+  
+}
+
+func eventually(f func() bool) bool {
+  deadline := time.Now().Add(time.Second)
+  for !f() {
+    if time.Now().After(deadline) {
+      return false
+    }
+    time.Sleep(time.Millisecond)
+  }
+  return true
+}
+
@@ -0,0 +1,262 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "sync"
+  "time"
+  "sync/atomic"
+)
+
+/**
+ * A value which is kept current by watching its key. The value is converted when it
+ * changes, so reading it is cheap and doesn't involve any locking.
+ */
+type handle struct {
+  state     sync.Mutex
+  config    Config
+  key       string
+  def       interface{}
+  convert   func(interface{}) (interface{}, error)
+  value     atomic.Value
+  onChange  []func(interface{}, interface{})
+}
+
+/**
+ * Create a handle. If the configuration is Observable the key is watched; otherwise the
+ * value is read once. The key is watched before it is first read so that a change made
+ * in between isn't missed.
+ */
+func newHandle(config Config, key string, def interface{}, convert func(interface{}) (interface{}, error)) *handle {
+  h := &handle{config:config, key:key, def:def, convert:convert}
+  h.value.Store(def)
+  if o, ok := config.(Observable); ok {
+    o.Watch(key, func(string, interface{}) {
+      h.refresh()
+    })
+  }
+  h.refresh()
+  return h
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *handle) load() interface{} {
+  return h.value.Load()
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *handle) addObserver(f func(interface{}, interface{})) {
+  h.state.Lock()
+  defer h.state.Unlock()
+  h.onChange = append(h.onChange, f)
+}
+
+/**
+ * Read the value again. We read rather than using the value from the change event since
+ * events may be delivered out of order. If the key doesn't exist, the default is used; if
+ * the value can't be converted, the current value is kept.
+ */
+func (h *handle) refresh() {
+  h.state.Lock()
+  
+  var val interface{}
+  v, err := h.config.Get(h.key)
+  if err == NoSuchKeyError {
+    val = h.def
+  }else if err != nil {
+    h.state.Unlock()
+    return
+  }else if val, err = h.convert(v); err != nil {
+    h.state.Unlock()
+    return
+  }
+  
+  prev := h.value.Load()
+  if prev == val {
+    h.state.Unlock()
+    return
+  }
+  h.value.Store(val)
+  
+  observers := make([]func(interface{}, interface{}), len(h.onChange))
+  copy(observers, h.onChange)
+  h.state.Unlock()
+  
+  for _, f := range observers {
+    f(prev, val)
+  }
+}
+
+/**
+ * A live integer value
+ */
+type IntHandle struct {
+  h *handle
+}
+
+/**
+ * Create a live integer value for a key. If the key doesn't exist, the default is used.
+ */
+func IntVar(config Config, key string, def int64) *IntHandle {
+  return &IntHandle{newHandle(config, key, def, func(v interface{}) (interface{}, error) { return AsInt(v) })}
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *IntHandle) Get() int64 {
+  return h.h.load().(int64)
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *IntHandle) OnChange(f func(prev, curr int64)) {
+  h.h.addObserver(func(prev, curr interface{}) { f(prev.(int64), curr.(int64)) })
+}
+
+/**
+ * A live floating point value
+ */
+type FloatHandle struct {
+  h *handle
+}
+
+/**
+ * Create a live floating point value for a key. If the key doesn't exist, the default
+ * is used.
+ */
+func FloatVar(config Config, key string, def float64) *FloatHandle {
+  return &FloatHandle{newHandle(config, key, def, func(v interface{}) (interface{}, error) { return AsFloat(v) })}
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *FloatHandle) Get() float64 {
+  return h.h.load().(float64)
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *FloatHandle) OnChange(f func(prev, curr float64)) {
+  h.h.addObserver(func(prev, curr interface{}) { f(prev.(float64), curr.(float64)) })
+}
+
+/**
+ * A live boolean value
+ */
+type BoolHandle struct {
+  h *handle
+}
+
+/**
+ * Create a live boolean value for a key. If the key doesn't exist, the default is used.
+ */
+func BoolVar(config Config, key string, def bool) *BoolHandle {
+  return &BoolHandle{newHandle(config, key, def, func(v interface{}) (interface{}, error) { return AsBool(v) })}
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *BoolHandle) Get() bool {
+  return h.h.load().(bool)
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *BoolHandle) OnChange(f func(prev, curr bool)) {
+  h.h.addObserver(func(prev, curr interface{}) { f(prev.(bool), curr.(bool)) })
+}
+
+/**
+ * A live string value
+ */
+type StringHandle struct {
+  h *handle
+}
+
+/**
+ * Create a live string value for a key. If the key doesn't exist, the default is used.
+ */
+func StringVar(config Config, key string, def string) *StringHandle {
+  return &StringHandle{newHandle(config, key, def, func(v interface{}) (interface{}, error) { return AsString(v) })}
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *StringHandle) Get() string {
+  return h.h.load().(string)
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *StringHandle) OnChange(f func(prev, curr string)) {
+  h.h.addObserver(func(prev, curr interface{}) { f(prev.(string), curr.(string)) })
+}
+
+/**
+ * A live duration value
+ */
+type DurationHandle struct {
+  h *handle
+}
+
+/**
+ * Create a live duration value for a key. If the key doesn't exist, the default is used.
+ */
+func DurationVar(config Config, key string, def time.Duration) *DurationHandle {
+  return &DurationHandle{newHandle(config, key, def, func(v interface{}) (interface{}, error) { return AsDuration(v) })}
+}
+
+/**
+ * Obtain the current value
+ */
+func (h *DurationHandle) Get() time.Duration {
+  return h.h.load().(time.Duration)
+}
+
+/**
+ * Add a function which is called when the value changes
+ */
+func (h *DurationHandle) OnChange(f func(prev, curr time.Duration)) {
+  h.h.addObserver(func(prev, curr interface{}) { f(prev.(time.Duration), curr.(time.Duration)) })
+}
+
@@ -0,0 +1,117 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "sync"
+  "time"
+  "testing"
+)
+
+func TestHandles(t *testing.T) {
+  
+  m := NewMemoryConfig(map[string]interface{}{
+    "pool.size": "4",
+    "pool.timeout": "5s",
+  })
+  
+  size := IntVar(m, "pool.size", 10)
+  if v := size.Get(); v != 4 {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  timeout := DurationVar(m, "pool.timeout", time.Second)
+  if v := timeout.Get(); v != time.Second * 5 {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  debug := BoolVar(m, "pool.debug", true)
+  if v := debug.Get(); !v {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  changes := make(chan [2]int64, 4)
+  size.OnChange(func(prev, curr int64) {
+    changes <- [2]int64{prev, curr}
+  })
+  
+  m.Set("pool.size", 8)
+  if c := <- changes; c != [2]int64{4, 8} {
+    t.Errorf("Unexpected change: %v", c)
+  }
+  if v := size.Get(); v != 8 {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // an invalid value is ignored
+  m.Set("pool.size", "Nope")
+  m.Set("pool.name", "Unrelated")
+  select {
+    case c := <- changes:
+      t.Errorf("Unexpected change: %v", c)
+    case <- time.After(time.Millisecond * 50):
+  }
+  if v := size.Get(); v != 8 {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // a removed value reverts to the default
+  m.Delete("pool.size")
+  if c := <- changes; c != [2]int64{8, 10} {
+    t.Errorf("Unexpected change: %v", c)
+  }
+  
+  // configurations which can't be observed are read once
+  s := StringVar(NewConfigSuite(m), "pool.name", "Default")
+  if v := s.Get(); v != "Unrelated" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // a change made just after the value is first read is not missed
+  var once sync.Once
+  c := Wrap(m, func(op *Operation, next Invoker) (interface{}, error) {
+    v, err := next(op)
+    if op.Op == OpGet && op.Key == "pool.name" {
+      once.Do(func() { m.Set("pool.name", "Changed") })
+    }
+    return v, err
+  })
+  s = StringVar(c, "pool.name", "Default")
+  deadline := time.Now().Add(time.Second)
+  for s.Get() != "Changed" && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+  }
+  if v := s.Get(); v != "Changed" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+}
+
@@ -0,0 +1,233 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+/**
+ * Operations
+ */
+const (
+  OpGet     = "get"
+  OpSet     = "set"
+  OpDelete  = "delete"
+  OpWatch   = "watch"
+)
+
+/**
+ * An operation on a configuration. For a set, the value is the value being set; for
+ * a watch, it is the value delivered with the change.
+ */
+type Operation struct {
+  Op          string
+  Key         string
+  Value       interface{}
+}
+
+/**
+ * Performs an operation, producing its result
+ */
+type Invoker func(op *Operation) (interface{}, error)
+
+/**
+ * An interceptor. Interceptors see every operation on a wrapped configuration and call
+ * the next invoker to proceed with it. An interceptor may inspect or replace the result
+ * and error produced by the operation, or it may not proceed with the operation at all.
+ * An error produced for a watch operation suppresses that change.
+ */
+type Interceptor func(op *Operation, next Invoker) (interface{}, error)
+
+/**
+ * A configuration wrapped by interceptors
+ */
+type interceptedConfig struct {
+  config        Config
+  interceptors  []Interceptor
+}
+
+/**
+ * A configuration wrapped by interceptors which can be observed
+ */
+type observableInterceptedConfig struct {
+  *interceptedConfig
+}
+
+/**
+ * Wrap a configuration with interceptors. Interceptors are applied in order; the first
+ * interceptor sees an operation first and its result last. The result is Observable
+ * only if the wrapped configuration is.
+ */
+func Wrap(cfg Config, interceptors ...Interceptor) Config {
+  c := &interceptedConfig{cfg, interceptors}
+  if _, ok := cfg.(Observable); ok {
+    return &observableInterceptedConfig{c}
+  }
+  return c
+}
+
+/**
+ * Invoke an operation through the interceptor chain
+ */
+func (c *interceptedConfig) invoke(op *Operation, final Invoker) (interface{}, error) {
+  return c.next(0, final)(op)
+}
+
+/**
+ * Obtain the invoker for the interceptor at the provided index
+ */
+func (c *interceptedConfig) next(i int, final Invoker) Invoker {
+  if i >= len(c.interceptors) {
+    return final
+  }
+  return func(op *Operation) (interface{}, error) {
+    return c.interceptors[i](op, c.next(i + 1, final))
+  }
+}
+
+/**
+ * Obtain a configuration value.
+ */
+func (c *interceptedConfig) Get(key string) (interface{}, error) {
+  return c.invoke(&Operation{Op:OpGet, Key:key}, func(op *Operation) (interface{}, error) {
+    return c.config.Get(op.Key)
+  })
+}
+
+/**
+ * Set a configuration value. The canonical form of the value is returned.
+ */
+func (c *interceptedConfig) Set(key string, value interface{}) (interface{}, error) {
+  return c.invoke(&Operation{Op:OpSet, Key:key, Value:value}, func(op *Operation) (interface{}, error) {
+    return c.config.Set(op.Key, op.Value)
+  })
+}
+
+/**
+ * Delete a configuration key/value.
+ */
+func (c *interceptedConfig) Delete(key string) error {
+  _, err := c.invoke(&Operation{Op:OpDelete, Key:key}, func(op *Operation) (interface{}, error) {
+    return nil, c.config.Delete(op.Key)
+  })
+  return err
+}
+
+/**
+ * Watch a configuration value for changes asynchronously.
+ */
+func (c *observableInterceptedConfig) Watch(key string, observer Observer) {
+  c.config.(Observable).Watch(key, func(key string, value interface{}) {
+    res, err := c.invoke(&Operation{Op:OpWatch, Key:key, Value:value}, func(op *Operation) (interface{}, error) {
+      return op.Value, nil
+    })
+    if err == nil {
+      observer(key, res)
+    }
+  })
+}
+
+/**
+ * Describe where a key is defined, if the wrapped configuration can. This is not an
+ * operation and is not intercepted.
+ */
+func (c *interceptedConfig) Locate(key string) (*Location, error) {
+  if l, ok := c.config.(Locator); ok {
+    return l.Locate(key)
+  }
+  return nil, nil
+}
+
+/**
+ * List keys, if the wrapped configuration can. This is not an operation and is not
+ * intercepted.
+ */
+func (c *interceptedConfig) Keys(prefix string) ([]string, error) {
+  if l, ok := c.config.(Lister); ok {
+    return l.Keys(prefix)
+  }
+  return nil, NotListableError
+}
+
+/**
+ * A tracer, which produces spans describing operations
+ */
+type Tracer interface {
+  Start(name string) Span
+}
+
+/**
+ * A span, which describes a single operation
+ */
+type Span interface {
+  SetAttribute(key string, value interface{})
+  SetError(err error)
+  End()
+}
+
+/**
+ * Create an interceptor which produces a span for every operation. Spans are named for
+ * the operation (e.g., "conf.get") and carry the key as an attribute; values are not
+ * recorded, since they may be sensitive.
+ */
+func TracingInterceptor(tracer Tracer) Interceptor {
+  return func(op *Operation, next Invoker) (interface{}, error) {
+    span := tracer.Start("conf."+ op.Op)
+    defer span.End()
+    span.SetAttribute("conf.key", op.Key)
+    res, err := next(op)
+    if err != nil {
+      span.SetError(err)
+    }
+    return res, err
+  }
+}
+
+/**
+ * Create an interceptor which logs every change made through the wrapped configuration
+ * (i.e., sets and deletes) and its outcome. Values are not logged, since they may be
+ * sensitive.
+ * 
+ * This is a diagnostic log, not an audit trail: it doesn't know who made a change, what
+ * the value was before or the index which resulted. Use Audited for a trail of changes
+ * which must be kept.
+ */
+func AuditInterceptor(logger Logger) Interceptor {
+  return func(op *Operation, next Invoker) (interface{}, error) {
+    if op.Op != OpSet && op.Op != OpDelete {
+      return next(op)
+    }
+    res, err := next(op)
+    if err != nil {
+      logger.Logf(LevelWarn, "[%s] %s failed: %v", op.Key, op.Op, err)
+    }else{
+      logger.Logf(LevelInfo, "[%s] %s", op.Key, op.Op)
+    }
+    return res, err
+  }
+}
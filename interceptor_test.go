@@ -0,0 +1,117 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+type testSpan struct {
+  tracer  *testTracer
+  name    string
+  err     error
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {}
+func (s *testSpan) SetError(err error) { s.err = err }
+func (s *testSpan) End() { s.tracer.ended = append(s.tracer.ended, s) }
+
+type testTracer struct {
+  ended   []*testSpan
+}
+
+func (t *testTracer) Start(name string) Span {
+  return &testSpan{tracer:t, name:name}
+}
+
+func TestInterceptors(t *testing.T) {
+  var order []string
+  
+  upper := func(op *Operation, next Invoker) (interface{}, error) {
+    order = append(order, "upper:"+ op.Op)
+    if s, ok := op.Value.(string); ok {
+      op.Value = strings.ToUpper(s)
+    }
+    return next(op)
+  }
+  
+  deny := func(op *Operation, next Invoker) (interface{}, error) {
+    order = append(order, "deny:"+ op.Op)
+    if op.Op == OpDelete {
+      return nil, errors.New("Denied")
+    }
+    return next(op)
+  }
+  
+  tracer := &testTracer{}
+  c := Wrap(NewMemoryConfig(nil), TracingInterceptor(tracer), upper, deny)
+  
+  v, err := c.Set("a", "value")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }else if v != "VALUE" {
+    t.Errorf("Value should be transformed: %v", v)
+  }
+  
+  v, err = c.Get("a")
+  if err != nil {
+    t.Errorf("Could not get: %v", err)
+  }else if v != "VALUE" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  err = c.Delete("a")
+  if err == nil {
+    t.Errorf("Delete should be denied")
+  }
+  
+  expect := "upper:set,deny:set,upper:get,deny:get,upper:delete,deny:delete"
+  if v := strings.Join(order, ","); v != expect {
+    t.Errorf("Unexpected order: %v", v)
+  }
+  
+  if len(tracer.ended) != 3 {
+    t.Errorf("Expected 3 spans, got %d", len(tracer.ended))
+  }else if s := tracer.ended[2]; s.name != "conf.delete" || s.err == nil {
+    t.Errorf("Unexpected span: %v, %v", s.name, s.err)
+  }
+  
+  // the result is only observable if the wrapped configuration is
+  if _, ok := c.(Observable); !ok {
+    t.Errorf("Wrapped configuration should be observable")
+  }
+  if _, ok := Wrap(struct{ Config }{NewMemoryConfig(nil)}).(Observable); ok {
+    t.Errorf("Wrapped configuration should not be observable")
+  }
+  
+}
@@ -0,0 +1,196 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "fmt"
+  "log"
+  "context"
+  "strings"
+  "net/url"
+  "log/slog"
+)
+
+/**
+ * Log levels
+ */
+type Level int
+
+const (
+  LevelDebug Level = iota
+  LevelInfo
+  LevelWarn
+  LevelError
+)
+
+/**
+ * Obtain a description of a level
+ */
+func (l Level) String() string {
+  switch l {
+    case LevelDebug:
+      return "DEBUG"
+    case LevelInfo:
+      return "INFO"
+    case LevelWarn:
+      return "WARN"
+    case LevelError:
+      return "ERROR"
+    default:
+      return fmt.Sprintf("LEVEL(%d)", int(l))
+  }
+}
+
+/**
+ * A logger. Requests are logged at LevelDebug; problems which are handled (e.g., by
+ * retrying) are logged at LevelWarn and problems which are not are logged at LevelError.
+ */
+type Logger interface {
+  Logf(level Level, format string, args ...interface{})
+}
+
+/**
+ * A logger which writes messages at or above a minimum level to the standard logger
+ */
+type stdLogger struct {
+  level   Level
+}
+
+/**
+ * Create a logger which writes messages at or above the provided level to the standard
+ * logger.
+ */
+func NewStdLogger(level Level) Logger {
+  return stdLogger{level}
+}
+
+/**
+ * Log a message
+ */
+func (l stdLogger) Logf(level Level, format string, args ...interface{}) {
+  if level >= l.level {
+    log.Printf(format, args...)
+  }
+}
+
+/**
+ * A logger which writes to a structured logger
+ */
+type slogLogger struct {
+  logger  *slog.Logger
+}
+
+/**
+ * Create a logger which writes to the provided structured logger. Levels are filtered
+ * by the structured logger's handler.
+ */
+func NewSlogLogger(logger *slog.Logger) Logger {
+  return slogLogger{logger}
+}
+
+/**
+ * Log a message
+ */
+func (l slogLogger) Logf(level Level, format string, args ...interface{}) {
+  var v slog.Level
+  switch level {
+    case LevelDebug:
+      v = slog.LevelDebug
+    case LevelInfo:
+      v = slog.LevelInfo
+    case LevelWarn:
+      v = slog.LevelWarn
+    default:
+      v = slog.LevelError
+  }
+  ctx := context.Background()
+  if l.logger.Enabled(ctx, v) {
+    l.logger.Log(ctx, v, fmt.Sprintf(format, args...))
+  }
+}
+
+/**
+ * The default logger, which does not log requests
+ */
+var defaultLogger = NewStdLogger(LevelInfo)
+
+/**
+ * Replaces values that must not be logged
+ */
+const redacted = "[REDACTED]"
+
+/**
+ * Determine if a key is under any of the provided prefixes
+ */
+func keyHasPrefix(key string, prefixes []string) bool {
+  for _, p := range prefixes {
+    if p == "" || key == p || strings.HasPrefix(key, p +".") {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Obtain a description of request parameters which is safe to log for the provided key.
+ * If the key is sensitive, values are redacted.
+ */
+func redactValues(key string, vals url.Values, sensitive []string) string {
+  if len(vals) < 1 || !keyHasPrefix(key, sensitive) {
+    return vals.Encode()
+  }
+  safe := url.Values{}
+  for k, v := range vals {
+    switch k {
+      case "value", "prevValue":
+        safe.Set(k, redacted)
+      default:
+        safe[k] = v
+    }
+  }
+  return strings.Replace(safe.Encode(), url.QueryEscape(redacted), redacted, -1)
+}
+
+/**
+ * Obtain a description of a request URL which is safe to log for the provided key
+ */
+func redactURL(key string, u *url.URL, sensitive []string) string {
+  if u.RawQuery == "" || !keyHasPrefix(key, sensitive) {
+    return u.String()
+  }
+  vals, err := url.ParseQuery(u.RawQuery)
+  if err != nil {
+    return redacted
+  }
+  safe := *u
+  safe.RawQuery = redactValues(key, vals, sensitive)
+  return safe.String()
+}
@@ -0,0 +1,68 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "strings"
+  "testing"
+  "net/url"
+)
+
+func TestRedaction(t *testing.T) {
+  sensitive := []string{"secrets", "db.password"}
+  
+  vals := url.Values{}
+  vals.Set("value", "hunter2")
+  vals.Set("prevValue", "hunter1")
+  vals.Set("ttl", "10")
+  
+  for _, key := range []string{"secrets", "secrets.api", "db.password"} {
+    v := redactValues(key, vals, sensitive)
+    if strings.Contains(v, "hunter") {
+      t.Errorf("%v: value should be redacted: %v", key, v)
+    }else if !strings.Contains(v, "ttl=10") {
+      t.Errorf("%v: other parameters should be preserved: %v", key, v)
+    }
+  }
+  
+  for _, key := range []string{"secretsx", "db", "db.host"} {
+    v := redactValues(key, vals, sensitive)
+    if !strings.Contains(v, "hunter2") {
+      t.Errorf("%v: value should not be redacted: %v", key, v)
+    }
+  }
+  
+  u, _ := url.Parse("http://localhost:4001/v2/keys/secrets/api?prevValue=hunter2")
+  if v := redactURL("secrets.api", u, sensitive); strings.Contains(v, "hunter") {
+    t.Errorf("Value should be redacted: %v", v)
+  }
+  
+}
@@ -30,27 +30,56 @@
 
 package conf
 
+import (
+  "sort"
+  "sync"
+  "time"
+  "strings"
+)
+
 /**
  * An in-memory configuration
  */
 type MemoryConfig struct {
-  config  map[string]interface{}
+  sync.RWMutex
+  config    map[string]interface{}
+  expires   map[string]*time.Timer
+  observers map[string][]EventObserver
+  history   map[string][]*Version
+  trimmed   map[string]bool
+  limit     int
+  index     int64
 }
 
 /**
- * Create a memory config backed by the specified map
+ * Create a memory config backed by the specified map. Each of the initial values is
+ * given its own index, in key order.
  */
 func NewMemoryConfig(c map[string]interface{}) *MemoryConfig {
   if c == nil {
     c = make(map[string]interface{})
   }
-  return &MemoryConfig{c}
+  m := &MemoryConfig{config:c, expires:make(map[string]*time.Timer), observers:make(map[string][]EventObserver), history:make(map[string][]*Version), trimmed:make(map[string]bool), limit:DefaultHistoryLimit}
+  
+  keys := make([]string, 0, len(c))
+  for k := range c {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  for _, k := range keys {
+    m.index++
+    m.remember(k, c[k], false)
+  }
+  
+  return m
 }
 
 /**
  * Obtain a configuration value.
  */
 func (c *MemoryConfig) Get(key string) (interface{}, error) {
+  c.RLock()
+  defer c.RUnlock()
   if v, ok := c.config[key]; ok {
     return v, nil
   }else{
@@ -58,18 +87,225 @@ func (c *MemoryConfig) Get(key string) (interface{}, error) {
   }
 }
 
+/**
+ * Obtain a configuration value and the index at which it was last modified, which can
+ * be used in atomic operations.
+ */
+func (c *MemoryConfig) GetWithIndex(key string) (interface{}, int64, error) {
+  c.RLock()
+  defer c.RUnlock()
+  if v, ok := c.config[key]; ok {
+    return v, c.modified(key), nil
+  }else{
+    return nil, -1, NoSuchKeyError
+  }
+}
+
 /**
  * Set a configuration value. The canonical form of the value is returned.
  */
 func (c *MemoryConfig) Set(key string, value interface{}) (interface{}, error) {
+  v, _, err := c.SetWithIndex(key, value)
+  return v, err
+}
+
+/**
+ * Set a configuration value and obtain the index at which it was modified. The
+ * canonical form of the value is returned.
+ */
+func (c *MemoryConfig) SetWithIndex(key string, value interface{}) (interface{}, int64, error) {
+  c.Lock()
+  defer c.Unlock()
+  c.cancelExpiry(key)
   c.config[key] = value
+  c.notify(ActionSet, key, value)
+  return value, c.index, nil
+}
+
+/**
+ * Set a configuration value if it has not been modified since the provided index. If
+ * the index is negative, the value is only set if the key does not exist. The canonical
+ * form of the value and the index at which it was modified are returned.
+ */
+func (c *MemoryConfig) CompareAndSwap(key string, value interface{}, prev int64) (interface{}, int64, error) {
+  if prev == 0 {
+    return nil, -1, InvalidIndexError
+  }
+  c.Lock()
+  defer c.Unlock()
+  
+  _, exists := c.config[key]
+  if prev < 0 && exists {
+    return nil, -1, KeyCollisionError
+  }else if prev > 0 && !exists {
+    return nil, -1, NoSuchKeyError
+  }else if prev > 0 && c.modified(key) != prev {
+    return nil, -1, ComparisonFailedError
+  }
+  
+  c.cancelExpiry(key)
+  c.config[key] = value
+  c.notify(ActionCompareAndSwap, key, value)
+  return value, c.index, nil
+}
+
+/**
+ * Set a configuration value which expires after the provided TTL unless it is
+ * refreshed or set again. The canonical form of the value is returned.
+ */
+func (c *MemoryConfig) SetWithTTL(key string, value interface{}, ttl time.Duration) (interface{}, error) {
+  if ttl <= 0 {
+    return nil, InvalidTTLError
+  }
+  c.Lock()
+  defer c.Unlock()
+  c.config[key] = value
+  c.expireAfter(key, ttl)
+  c.notify(ActionSet, key, value)
   return value, nil
 }
 
+/**
+ * Refresh the TTL of a key without changing its value.
+ */
+func (c *MemoryConfig) RefreshTTL(key string, ttl time.Duration) error {
+  if ttl <= 0 {
+    return InvalidTTLError
+  }
+  c.Lock()
+  defer c.Unlock()
+  if _, ok := c.config[key]; !ok {
+    return NoSuchKeyError
+  }
+  c.expireAfter(key, ttl)
+  return nil
+}
+
 /**
  * Delete a configuration key/value.
  */
 func (c *MemoryConfig) Delete(key string) error {
+  _, err := c.DeleteWithIndex(key)
+  return err
+}
+
+/**
+ * Delete a configuration key/value and obtain the index at which it was deleted. If the
+ * key does not exist nothing changes and the index is zero.
+ */
+func (c *MemoryConfig) DeleteWithIndex(key string) (int64, error) {
+  c.Lock()
+  defer c.Unlock()
+  c.cancelExpiry(key)
+  if _, ok := c.config[key]; !ok {
+    return 0, nil
+  }
   delete(c.config, key)
-  return nil
+  c.notify(ActionDelete, key, nil)
+  return c.index, nil
+}
+
+/**
+ * List the keys which are equal to or under the provided prefix.
+ */
+func (c *MemoryConfig) Keys(prefix string) ([]string, error) {
+  c.RLock()
+  defer c.RUnlock()
+  keys := make([]string, 0)
+  for k := range c.config {
+    if keyHasPrefix(k, []string{prefix}) {
+      keys = append(keys, k)
+    }
+  }
+  return keys, nil
+}
+
+/**
+ * Obtain the values of the keys which are equal to or under the provided prefix.
+ */
+func (c *MemoryConfig) Values(prefix string) (map[string]interface{}, error) {
+  c.RLock()
+  defer c.RUnlock()
+  vals := make(map[string]interface{})
+  for k, v := range c.config {
+    if keyHasPrefix(k, []string{prefix}) {
+      vals[k] = v
+    }
+  }
+  return vals, nil
+}
+
+/**
+ * Watch a configuration value for changes. The observer is also notified of changes to
+ * keys under the watched key (e.g., watching "a" observes changes to "a.b").
+ */
+func (c *MemoryConfig) Watch(key string, observer Observer) {
+  c.WatchEvents(key, func(v *Event) {
+    observer(v.Key, v.Value)
+  })
+}
+
+/**
+ * Watch a configuration value for change events. Unlike Watch, the observer is told
+ * what kind of change occurred (e.g., a key which expires produces an event with the
+ * action ActionExpire).
+ */
+func (c *MemoryConfig) WatchEvents(key string, observer EventObserver) {
+  c.Lock()
+  defer c.Unlock()
+  c.observers[key] = append(c.observers[key], observer)
+}
+
+/**
+ * Record a change to a key and notify observers of the key and of its ancestors (no sync)
+ */
+func (c *MemoryConfig) notify(action, key string, value interface{}) {
+  c.index++
+  switch action {
+    case ActionDelete, ActionExpire, ActionCompareAndDelete:
+      c.remember(key, nil, true)
+    default:
+      c.remember(key, value, false)
+  }
+  
+  event := &Event{Action:action, Key:key, Value:value, Index:c.index}
+  for k := key; ; {
+    for _, o := range c.observers[k] {
+      go o(event)
+    }
+    i := strings.LastIndex(k, ".")
+    if i < 0 {
+      break
+    }
+    k = k[:i]
+  }
+}
+
+/**
+ * Schedule a key to expire, replacing any existing expiry (no sync)
+ */
+func (c *MemoryConfig) expireAfter(key string, ttl time.Duration) {
+  c.cancelExpiry(key)
+  var t *time.Timer
+  t = time.AfterFunc(ttl, func() {
+    c.Lock()
+    defer c.Unlock()
+    // make sure we haven't been replaced or canceled in the meantime
+    if c.expires[key] == t {
+      delete(c.expires, key)
+      delete(c.config, key)
+      c.notify(ActionExpire, key, nil)
+    }
+  })
+  c.expires[key] = t
+}
+
+/**
+ * Cancel a pending expiry, if there is one (no sync)
+ */
+func (c *MemoryConfig) cancelExpiry(key string) {
+  if t, ok := c.expires[key]; ok {
+    t.Stop()
+    delete(c.expires, key)
+  }
 }
@@ -0,0 +1,187 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "time"
+  "errors"
+)
+
+var NoSuchVersionError = errors.New("No such version")
+
+/**
+ * The number of versions of each key a memory config retains by default
+ */
+const DefaultHistoryLimit = 10
+
+/**
+ * A version of a key. Versions are identified by the index at which they were made,
+ * which increases with every change to the configuration.
+ */
+type Version struct {
+  Index     int64
+  Value     interface{}
+  Deleted   bool
+  Time      time.Time
+}
+
+/**
+ * Set the number of versions of each key which are retained. At least the current
+ * version is always retained. Keys which already have more versions than this are
+ * trimmed when they next change.
+ */
+func (c *MemoryConfig) SetHistoryLimit(n int) {
+  if n < 1 {
+    n = 1
+  }
+  c.Lock()
+  defer c.Unlock()
+  c.limit = n
+}
+
+/**
+ * Obtain the retained versions of a key, oldest first. A key which has been deleted
+ * still has a history, ending with the version that deleted it.
+ */
+func (c *MemoryConfig) History(key string) ([]*Version, error) {
+  c.RLock()
+  defer c.RUnlock()
+  h, ok := c.history[key]
+  if !ok {
+    return nil, NoSuchKeyError
+  }
+  res := make([]*Version, len(h))
+  for i, e := range h {
+    v := *e
+    res[i] = &v
+  }
+  return res, nil
+}
+
+/**
+ * Obtain the value of a key as of the provided index; that is, the value of the latest
+ * version made at or before it. If the key did not exist as of that index, NoSuchKeyError
+ * is returned; if the version has not been retained, NoSuchVersionError is returned.
+ */
+func (c *MemoryConfig) GetAt(key string, index int64) (interface{}, error) {
+  c.RLock()
+  defer c.RUnlock()
+  v, err := c.versionAt(key, index)
+  if err != nil {
+    return nil, err
+  }else if v.Deleted {
+    return nil, NoSuchKeyError
+  }
+  return v.Value, nil
+}
+
+/**
+ * Restore a key to its value as of the provided index. This makes a new version, so the
+ * rollback itself can be rolled back. If the key did not exist as of that index, it is
+ * deleted. The restored value is returned.
+ */
+func (c *MemoryConfig) Rollback(key string, index int64) (interface{}, error) {
+  c.Lock()
+  defer c.Unlock()
+  
+  v, err := c.versionAt(key, index)
+  if err != nil {
+    return nil, err
+  }
+  
+  c.cancelExpiry(key)
+  if v.Deleted {
+    if _, ok := c.config[key]; ok {
+      delete(c.config, key)
+      c.notify(ActionDelete, key, nil)
+    }
+    return nil, nil
+  }
+  
+  c.config[key] = v.Value
+  c.notify(ActionSet, key, v.Value)
+  return v.Value, nil
+}
+
+/**
+ * Describe where a key is defined, which is the index at which it was last modified.
+ */
+func (c *MemoryConfig) Locate(key string) (*Location, error) {
+  c.RLock()
+  defer c.RUnlock()
+  if _, ok := c.config[key]; !ok {
+    return nil, NoSuchKeyError
+  }
+  return &Location{Index:c.modified(key)}, nil
+}
+
+/**
+ * Find the version of a key as of an index (no sync)
+ */
+func (c *MemoryConfig) versionAt(key string, index int64) (*Version, error) {
+  h, ok := c.history[key]
+  if !ok {
+    return nil, NoSuchKeyError
+  }
+  for i := len(h) - 1; i >= 0; i-- {
+    if h[i].Index <= index {
+      return h[i], nil
+    }
+  }
+  if c.trimmed[key] {
+    return nil, NoSuchVersionError
+  }
+  return nil, NoSuchKeyError // we have the complete history; the key didn't exist yet
+}
+
+/**
+ * Obtain the index at which a key was last modified (no sync)
+ */
+func (c *MemoryConfig) modified(key string) int64 {
+  if h := c.history[key]; len(h) > 0 {
+    return h[len(h) - 1].Index
+  }
+  return 0
+}
+
+/**
+ * Record a new version of a key at the current index, discarding the oldest versions
+ * beyond our limit (no sync)
+ */
+func (c *MemoryConfig) remember(key string, value interface{}, deleted bool) {
+  h := append(c.history[key], &Version{Index:c.index, Value:value, Deleted:deleted, Time:time.Now()})
+  if n := len(h) - c.limit; n > 0 {
+    h = append([]*Version(nil), h[n:]...)
+    c.trimmed[key] = true
+  }
+  c.history[key] = h
+}
+
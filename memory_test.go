@@ -0,0 +1,219 @@
+// 
+// Go Config
+// Copyright (c) 2014, 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "time"
+  "testing"
+)
+
+func TestMemoryTTL(t *testing.T) {
+  
+  m := NewMemoryConfig(nil)
+  key := "test.a.b.c"
+  
+  _, err := m.SetWithTTL(key, "Expires", 0)
+  if err != InvalidTTLError {
+    t.Errorf("TTL should be invalid: %v", err)
+  }
+  
+  _, err = m.SetWithTTL(key, "Expires", time.Millisecond * 50)
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  
+  v, err := m.Get(key)
+  if err != nil {
+    t.Errorf("Could not get: %v", err)
+  }else if v != "Expires" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  <- time.After(time.Millisecond * 100)
+  
+  _, err = m.Get(key)
+  if err != NoSuchKeyError {
+    t.Errorf("Key should have expired: %v", err)
+  }
+  
+  err = m.RefreshTTL(key, time.Second)
+  if err != NoSuchKeyError {
+    t.Errorf("Expired key should not be refreshed: %v", err)
+  }
+  
+  _, err = m.SetWithTTL(key, "Persists", time.Millisecond * 50)
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  _, err = m.Set(key, "Persists")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  
+  <- time.After(time.Millisecond * 100)
+  
+  _, err = m.Get(key)
+  if err != nil {
+    t.Errorf("Key should not have expired: %v", err)
+  }
+  
+}
+
+func TestMemoryWatch(t *testing.T) {
+  
+  m := NewMemoryConfig(nil)
+  w := make(chan *Event, 4)
+  
+  m.WatchEvents("test.watch", func(v *Event) {
+    w <- v
+  })
+  
+  _, err := m.Set("test.watch.a", "Value")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  v := <- w
+  if v.Action != ActionSet || v.Key != "test.watch.a" || v.Value != "Value" {
+    t.Errorf("Unexpected event: %+v", v)
+  }
+  
+  err = m.Delete("test.watch.a")
+  if err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }
+  v = <- w
+  if v.Action != ActionDelete || v.Key != "test.watch.a" || v.Value != nil {
+    t.Errorf("Unexpected event: %+v", v)
+  }
+  
+  _, err = m.SetWithTTL("test.watch", "Expires", time.Millisecond * 10)
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  <- w
+  v = <- w
+  if v.Action != ActionExpire || v.Key != "test.watch" {
+    t.Errorf("Unexpected event: %+v", v)
+  }
+  
+  _, err = m.Set("test.other", "Unobserved")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  select {
+    case v := <- w:
+      t.Errorf("Unexpected event: %+v", v)
+    case <- time.After(time.Millisecond * 50):
+  }
+  
+}
+func TestMemoryHistory(t *testing.T) {
+  
+  m := NewMemoryConfig(map[string]interface{}{"a": "1"})
+  m.SetHistoryLimit(3)
+  
+  _, i2, err := m.SetWithIndex("a", "2")
+  if err != nil || i2 != 2 {
+    t.Errorf("Could not set: %v, %v", i2, err)
+  }
+  _, i3, err := m.SetWithIndex("a", "3")
+  if err != nil || i3 != 3 {
+    t.Errorf("Could not set: %v, %v", i3, err)
+  }
+  
+  v, err := m.GetAt("a", 1)
+  if err != nil || v != "1" {
+    t.Errorf("Unexpected value: %v, %v", v, err)
+  }
+  v, err = m.GetAt("a", i2)
+  if err != nil || v != "2" {
+    t.Errorf("Unexpected value: %v, %v", v, err)
+  }
+  
+  v, err = m.Rollback("a", 1)
+  if err != nil || v != "1" {
+    t.Errorf("Could not roll back: %v, %v", v, err)
+  }
+  v, err = m.Get("a")
+  if err != nil || v != "1" {
+    t.Errorf("Unexpected value: %v, %v", v, err)
+  }
+  
+  h, err := m.History("a")
+  if err != nil {
+    t.Errorf("Could not obtain history: %v", err)
+  }else if len(h) != 3 || h[0].Value != "2" || h[2].Value != "1" || h[2].Index != 4 {
+    t.Errorf("Unexpected history: %v", h)
+  }
+  
+  _, err = m.GetAt("a", 1)
+  if err != NoSuchVersionError {
+    t.Errorf("Version should have been discarded: %v", err)
+  }
+  m.SetHistoryLimit(10)
+  _, err = m.GetAt("a", 1)
+  if err != NoSuchVersionError {
+    t.Errorf("Version should still have been discarded: %v", err)
+  }
+  
+  _, _, err = m.CompareAndSwap("a", "5", i3)
+  if err != ComparisonFailedError {
+    t.Errorf("Comparison should have failed: %v", err)
+  }
+  _, _, err = m.CompareAndSwap("a", "5", 4)
+  if err != nil {
+    t.Errorf("Could not compare and swap: %v", err)
+  }
+  
+  err = m.Delete("a")
+  if err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }
+  _, err = m.GetAt("a", 6)
+  if err != NoSuchKeyError {
+    t.Errorf("Key should have been deleted: %v", err)
+  }
+  v, err = m.Rollback("a", 5)
+  if err != nil || v != "5" {
+    t.Errorf("Could not roll back: %v, %v", v, err)
+  }
+  
+  _, err = m.Set("b", "B")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  _, err = m.GetAt("b", 1)
+  if err != NoSuchKeyError {
+    t.Errorf("Key should not have existed: %v", err)
+  }
+  
+}
+
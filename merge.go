@@ -0,0 +1,211 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "strings"
+  "encoding/json"
+)
+
+/**
+ * Strategies for merging lists
+ */
+type ListStrategy int
+
+const (
+  ListReplace     ListStrategy = iota // the higher priority list replaces the lower
+  ListAppend                          // the higher priority list is appended to the lower
+  ListMergeByKey                      // elements which are maps are merged when their keys match
+)
+
+/**
+ * How values are merged across the layers of a suite. Maps are merged recursively, with
+ * values from higher priority layers taking precedence; lists are merged according to
+ * the list strategy. Other values are not merged: the highest priority value is used.
+ * 
+ * String values which contain a JSON object or array are decoded before they are merged,
+ * so structured values stored as JSON (e.g., in etcd) can be merged with maps. A value
+ * which isn't actually merged with another (e.g., because it's only defined by one layer
+ * or because lists are replaced) is returned as it is stored, whichever layers define it.
+ */
+type MergeOptions struct {
+  
+  /**
+   * How lists are merged
+   */
+  Lists       ListStrategy
+  
+  /**
+   * When lists are merged by key, the map element which identifies each element of a
+   * list. If this is not provided, "id" is used.
+   */
+  MergeKey    string
+  
+}
+
+/**
+ * Merge a higher priority value onto a lower priority one. Neither value is modified.
+ * If the values can't be merged, the higher priority value is returned as-is.
+ */
+func (o *MergeOptions) merge(lower, higher interface{}) interface{} {
+  if res, ok := o.mergeStructured(decodeStructured(lower), decodeStructured(higher)); ok {
+    return res
+  }
+  return higher
+}
+
+/**
+ * Determine if a value is a map or list which can be merged with others
+ */
+func isStructured(v interface{}) bool {
+  switch decodeStructured(v).(type) {
+    case map[string]interface{}, []interface{}:
+      return true
+    default:
+      return false
+  }
+}
+
+/**
+ * Merge decoded values. If they are not merged, false is returned.
+ */
+func (o *MergeOptions) mergeStructured(lower, higher interface{}) (interface{}, bool) {
+  switch h := higher.(type) {
+    
+    case map[string]interface{}:
+      l, ok := lower.(map[string]interface{})
+      if !ok {
+        return nil, false
+      }
+      res := make(map[string]interface{}, len(l) + len(h))
+      for k, v := range l {
+        res[k] = v
+      }
+      for k, v := range h {
+        if e, ok := res[k]; ok {
+          res[k] = o.merge(e, v)
+        }else{
+          res[k] = v
+        }
+      }
+      return res, true
+      
+    case []interface{}:
+      l, ok := lower.([]interface{})
+      if !ok {
+        return nil, false
+      }
+      switch o.Lists {
+        case ListAppend:
+          res := make([]interface{}, 0, len(l) + len(h))
+          res = append(res, l...)
+          return append(res, h...), true
+        case ListMergeByKey:
+          return o.mergeByKey(l, h), true
+        default:
+          return nil, false
+      }
+      
+    default:
+      return nil, false
+      
+  }
+}
+
+/**
+ * Merge lists by key. Elements of the higher priority list replace or are merged onto
+ * elements of the lower priority list which have the same key; others are appended.
+ */
+func (o *MergeOptions) mergeByKey(lower, higher []interface{}) []interface{} {
+  field := o.MergeKey
+  if field == "" {
+    field = "id"
+  }
+  
+  res := make([]interface{}, len(lower), len(lower) + len(higher))
+  copy(res, lower)
+  
+  index := make(map[interface{}]int)
+  for i, e := range res {
+    if m, ok := decodeStructured(e).(map[string]interface{}); ok {
+      if k, ok := m[field]; ok && isComparable(k) {
+        index[k] = i
+      }
+    }
+  }
+  
+  for _, e := range higher {
+    if m, ok := decodeStructured(e).(map[string]interface{}); ok {
+      if k, ok := m[field]; ok && isComparable(k) {
+        if i, ok := index[k]; ok {
+          res[i] = o.merge(res[i], m)
+          continue
+        }
+        index[k] = len(res)
+      }
+    }
+    res = append(res, e)
+  }
+  
+  return res
+}
+
+/**
+ * Determine if a value can be used as a map key
+ */
+func isComparable(v interface{}) bool {
+  switch v.(type) {
+    case string, bool, float64, float32, int, int64, int32, uint, uint64, uint32:
+      return true
+    default:
+      return false
+  }
+}
+
+/**
+ * Decode a string which contains a JSON object or array. Other values are returned as-is.
+ */
+func decodeStructured(v interface{}) interface{} {
+  s, ok := v.(string)
+  if !ok {
+    return v
+  }
+  t := strings.TrimSpace(s)
+  if !strings.HasPrefix(t, "{") && !strings.HasPrefix(t, "[") {
+    return v
+  }
+  var d interface{}
+  if err := json.Unmarshal([]byte(t), &d); err != nil {
+    return v
+  }
+  return d
+}
+
@@ -0,0 +1,143 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "errors"
+  "reflect"
+  "testing"
+)
+
+func TestSuiteMerge(t *testing.T) {
+  
+  file := NewMemoryConfig(map[string]interface{}{
+    "db": map[string]interface{}{
+      "host": "localhost",
+      "port": 5432,
+      "pool": map[string]interface{}{"min": 1, "max": 10},
+      "replicas": []interface{}{
+        map[string]interface{}{"id": "a", "host": "a.local"},
+        map[string]interface{}{"id": "b", "host": "b.local"},
+      },
+    },
+    "name": "File",
+  })
+  etcd := NewMemoryConfig(map[string]interface{}{
+    "db": `{"host":"db.prod","pool":{"max":50},"replicas":[{"id":"b","host":"b.prod"},{"id":"c","host":"c.prod"}]}`,
+  })
+  
+  s := NewConfigSuite(etcd, file)
+  
+  // without merging, the highest priority value is used as-is
+  if v, _ := s.Get("db"); v != etcd.config["db"] {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  s.SetMerge(&MergeOptions{})
+  v, err := s.Get("db")
+  if err != nil {
+    t.Errorf("Could not get: %v", err)
+    return
+  }
+  m := v.(map[string]interface{})
+  if m["host"] != "db.prod" || m["port"] != 5432 {
+    t.Errorf("Unexpected value: %v", m)
+  }
+  if p := m["pool"].(map[string]interface{}); p["min"] != 1 || p["max"] != float64(50) {
+    t.Errorf("Unexpected value: %v", p)
+  }
+  if r := m["replicas"].([]interface{}); len(r) != 2 || r[0].(map[string]interface{})["id"] != "b" {
+    t.Errorf("Unexpected value: %v", r)
+  }
+  
+  s.SetMerge(&MergeOptions{Lists:ListAppend})
+  v, _ = s.Get("db")
+  if r := v.(map[string]interface{})["replicas"].([]interface{}); len(r) != 4 {
+    t.Errorf("Unexpected value: %v", r)
+  }
+  
+  s.SetMerge(&MergeOptions{Lists:ListMergeByKey})
+  v, _ = s.Get("db")
+  expect := []interface{}{
+    map[string]interface{}{"id": "a", "host": "a.local"},
+    map[string]interface{}{"id": "b", "host": "b.prod"},
+    map[string]interface{}{"id": "c", "host": "c.prod"},
+  }
+  if r := v.(map[string]interface{})["replicas"]; !reflect.DeepEqual(r, expect) {
+    t.Errorf("Unexpected value: %v", r)
+  }
+  
+  // the layers are not modified
+  if p := file.config["db"].(map[string]interface{})["pool"].(map[string]interface{}); p["max"] != 10 {
+    t.Errorf("Layer was modified: %v", p)
+  }
+  
+  // values which aren't structured are not merged
+  if v, _ := s.Get("name"); v != "File" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // values which aren't merged are returned as they are stored, however many layers
+  // define them
+  s.SetMerge(&MergeOptions{})
+  tags := `["a"]`
+  etcd.Set("tags", tags)
+  if v, _ := s.Get("tags"); v != tags {
+    t.Errorf("Unexpected value: %#v", v)
+  }
+  file.Set("tags", []interface{}{"b"})
+  if v, _ := s.Get("tags"); v != tags {
+    t.Errorf("Unexpected value: %#v", v)
+  }
+  if _, err := s.Get("missing"); err != NoSuchKeyError {
+    t.Errorf("Expected no such key: %v", err)
+  }
+  
+  // a layer which fails doesn't matter unless its value would be merged
+  broken := Wrap(NewMemoryConfig(nil), func(op *Operation, next Invoker) (interface{}, error) {
+    return nil, errors.New("Unavailable")
+  })
+  f := NewConfigSuite(NewMemoryConfig(map[string]interface{}{"port": "80", "db": map[string]interface{}{"host": "localhost"}}), broken)
+  f.SetMerge(&MergeOptions{})
+  if v, err := f.Get("port"); err != nil || v != "80" {
+    t.Errorf("Unexpected value: %v, %v", v, err)
+  }
+  if _, err := f.Get("db"); err == nil {
+    t.Errorf("Expected an error")
+  }
+  f = NewConfigSuite(broken, NewMemoryConfig(map[string]interface{}{"port": "80"}))
+  f.SetMerge(&MergeOptions{})
+  if _, err := f.Get("port"); err == nil {
+    t.Errorf("Expected an error")
+  }
+  
+}
+
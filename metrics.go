@@ -0,0 +1,358 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "fmt"
+  "sort"
+  "sync"
+  "errors"
+  "context"
+  "strings"
+  "net/http"
+)
+
+/**
+ * Metric names
+ */
+const (
+  MetricRequestDuration   = "conf_etcd_request_duration_seconds"  // histogram: op
+  MetricRequestErrors     = "conf_etcd_request_errors_total"      // counter: op, type
+  MetricCacheHits         = "conf_etcd_cache_hits_total"          // counter
+  MetricCacheMisses       = "conf_etcd_cache_misses_total"        // counter
+  MetricCacheEntries      = "conf_etcd_cache_entries"             // gauge
+  MetricCacheEvictions    = "conf_etcd_cache_evictions_total"     // counter
+  MetricWatchReconnects   = "conf_etcd_watch_reconnects_total"    // counter
+  MetricWatchLag          = "conf_etcd_watch_lag_index"           // histogram
+  MetricObservers         = "conf_etcd_observers"                 // gauge
+)
+
+/**
+ * A metrics sink. Labels are provided as alternating names and values.
+ */
+type Metrics interface {
+  
+  /**
+   * Add to a counter
+   */
+  Counter(name string, delta float64, labels ...string)
+  
+  /**
+   * Record an observation in a histogram
+   */
+  Observe(name string, value float64, labels ...string)
+  
+  /**
+   * Set a gauge
+   */
+  Gauge(name string, value float64, labels ...string)
+  
+}
+
+/**
+ * Metrics which go nowhere
+ */
+type nopMetrics struct {}
+
+func (m nopMetrics) Counter(name string, delta float64, labels ...string) {}
+func (m nopMetrics) Observe(name string, value float64, labels ...string) {}
+func (m nopMetrics) Gauge(name string, value float64, labels ...string) {}
+
+/**
+ * Classify an error for metrics
+ */
+func errorType(err error) string {
+  switch err {
+    case NoSuchKeyError:
+      return "not_found"
+    case KeyCollisionError:
+      return "key_exists"
+    case ComparisonFailedError:
+      return "comparison_failed"
+    case TimeoutError:
+      return "timeout"
+    case context.Canceled, context.DeadlineExceeded:
+      return "canceled"
+  }
+  var etcerr *etcdError
+  if errors.As(err, &etcerr) {
+    return fmt.Sprintf("etcd_%d", etcerr.Code)
+  }
+  return "other"
+}
+
+/**
+ * Default histogram buckets
+ */
+var (
+  DefaultDurationBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+  DefaultLagBuckets       = []float64{0, 1, 10, 100, 1000, 10000}
+)
+
+/**
+ * Metrics which are collected in memory and exposed in the Prometheus text format. This
+ * type is an http.Handler which can be mounted wherever Prometheus expects to scrape it.
+ */
+type PrometheusMetrics struct {
+  sync.Mutex
+  buckets     map[string][]float64
+  counters    map[string]map[string]float64
+  gauges      map[string]map[string]float64
+  histograms  map[string]map[string]*histogram
+}
+
+/**
+ * A histogram series
+ */
+type histogram struct {
+  counts      []uint64
+  count       uint64
+  sum         float64
+}
+
+/**
+ * Create Prometheus metrics
+ */
+func NewPrometheusMetrics() *PrometheusMetrics {
+  return &PrometheusMetrics{
+    buckets:    map[string][]float64{MetricWatchLag: DefaultLagBuckets},
+    counters:   make(map[string]map[string]float64),
+    gauges:     make(map[string]map[string]float64),
+    histograms: make(map[string]map[string]*histogram),
+  }
+}
+
+/**
+ * Set the buckets used for a histogram. Histograms use DefaultDurationBuckets unless
+ * otherwise specified. The buckets can't be changed once observations have been recorded
+ * for a histogram, since its series would no longer agree; if they have been, this does
+ * nothing and false is returned.
+ */
+func (m *PrometheusMetrics) SetBuckets(name string, buckets []float64) bool {
+  m.Lock()
+  defer m.Unlock()
+  if _, ok := m.histograms[name]; ok {
+    return false
+  }
+  b := append([]float64(nil), buckets...)
+  sort.Float64s(b)
+  m.buckets[name] = b
+  return true
+}
+
+/**
+ * Add to a counter
+ */
+func (m *PrometheusMetrics) Counter(name string, delta float64, labels ...string) {
+  m.Lock()
+  defer m.Unlock()
+  series(m.counters, name)[formatLabels(labels, "", "")] += delta
+}
+
+/**
+ * Set a gauge
+ */
+func (m *PrometheusMetrics) Gauge(name string, value float64, labels ...string) {
+  m.Lock()
+  defer m.Unlock()
+  series(m.gauges, name)[formatLabels(labels, "", "")] = value
+}
+
+/**
+ * Record an observation in a histogram
+ */
+func (m *PrometheusMetrics) Observe(name string, value float64, labels ...string) {
+  m.Lock()
+  defer m.Unlock()
+  
+  buckets, ok := m.buckets[name]
+  if !ok {
+    buckets = DefaultDurationBuckets
+  }
+  
+  s, ok := m.histograms[name]
+  if !ok {
+    s = make(map[string]*histogram)
+    m.histograms[name] = s
+  }
+  
+  k := strings.Join(labels, "\x00")
+  h, ok := s[k]
+  if !ok {
+    h = &histogram{counts:make([]uint64, len(buckets))}
+    s[k] = h
+  }
+  
+  for i, b := range buckets {
+    if value <= b {
+      h.counts[i]++
+    }
+  }
+  h.count++
+  h.sum += value
+}
+
+/**
+ * Obtain the series for a metric, creating it if necessary
+ */
+func series(m map[string]map[string]float64, name string) map[string]float64 {
+  s, ok := m[name]
+  if !ok {
+    s = make(map[string]float64)
+    m[name] = s
+  }
+  return s
+}
+
+/**
+ * Write all metrics in the Prometheus text format
+ */
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+  m.Lock()
+  defer m.Unlock()
+  
+  b := &strings.Builder{}
+  
+  for _, name := range sortedKeys(m.counters) {
+    fmt.Fprintf(b, "# TYPE %s counter\n", name)
+    writeSeries(b, name, m.counters[name])
+  }
+  
+  for _, name := range sortedKeys(m.gauges) {
+    fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+    writeSeries(b, name, m.gauges[name])
+  }
+  
+  hnames := make([]string, 0, len(m.histograms))
+  for name := range m.histograms {
+    hnames = append(hnames, name)
+  }
+  sort.Strings(hnames)
+  
+  for _, name := range hnames {
+    buckets, ok := m.buckets[name]
+    if !ok {
+      buckets = DefaultDurationBuckets
+    }
+    fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+    s := m.histograms[name]
+    keys := make([]string, 0, len(s))
+    for k := range s {
+      keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+      var labels []string
+      if k != "" {
+        labels = strings.Split(k, "\x00")
+      }
+      h := s[k]
+      for i, e := range buckets {
+        fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(labels, "le", formatFloat(e)), h.counts[i])
+      }
+      fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(labels, "le", "+Inf"), h.count)
+      fmt.Fprintf(b, "%s_sum%s %s\n", name, formatLabels(labels, "", ""), formatFloat(h.sum))
+      fmt.Fprintf(b, "%s_count%s %d\n", name, formatLabels(labels, "", ""), h.count)
+    }
+  }
+  
+  n, err := io.WriteString(w, b.String())
+  return int64(n), err
+}
+
+/**
+ * Serve metrics
+ */
+func (m *PrometheusMetrics) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+  rsp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+  m.WriteTo(rsp)
+}
+
+/**
+ * Write the series for a counter or gauge
+ */
+func writeSeries(w io.Writer, name string, s map[string]float64) {
+  keys := make([]string, 0, len(s))
+  for k := range s {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  for _, k := range keys {
+    fmt.Fprintf(w, "%s%s %s\n", name, k, formatFloat(s[k]))
+  }
+}
+
+/**
+ * Format labels, with an optional extra label appended
+ */
+func formatLabels(labels []string, name, value string) string {
+  if name != "" {
+    labels = append(append([]string(nil), labels...), name, value)
+  }
+  if len(labels) < 2 {
+    return ""
+  }
+  b := &strings.Builder{}
+  b.WriteString("{")
+  for i := 0; i + 1 < len(labels); i += 2 {
+    if i > 0 {
+      b.WriteString(",")
+    }
+    fmt.Fprintf(b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+  }
+  b.WriteString("}")
+  return b.String()
+}
+
+/**
+ * Escapes label values
+ */
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/**
+ * Format a sample value
+ */
+func formatFloat(v float64) string {
+  return fmt.Sprint(v)
+}
+
+/**
+ * Obtain the sorted keys of a map of series
+ */
+func sortedKeys(m map[string]map[string]float64) []string {
+  keys := make([]string, 0, len(m))
+  for k := range m {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  return keys
+}
@@ -0,0 +1,89 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+  m := NewPrometheusMetrics()
+  m.SetBuckets("test_duration_seconds", []float64{1, 0.1})
+  
+  m.Counter("test_errors_total", 1, "op", "get", "type", "timeout")
+  m.Counter("test_errors_total", 2, "op", "get", "type", "timeout")
+  m.Gauge("test_observers", 5)
+  m.Observe("test_duration_seconds", 0.05, "op", "get")
+  m.Observe("test_duration_seconds", 0.5, "op", "get")
+  m.Observe("test_duration_seconds", 5, "op", "get")
+  
+  b := &strings.Builder{}
+  m.WriteTo(b)
+  out := b.String()
+  
+  expect := []string{
+    "# TYPE test_errors_total counter\n",
+    `test_errors_total{op="get",type="timeout"} 3` +"\n",
+    "# TYPE test_observers gauge\n",
+    "test_observers 5\n",
+    "# TYPE test_duration_seconds histogram\n",
+    `test_duration_seconds_bucket{op="get",le="0.1"} 1` +"\n",
+    `test_duration_seconds_bucket{op="get",le="1"} 2` +"\n",
+    `test_duration_seconds_bucket{op="get",le="+Inf"} 3` +"\n",
+    `test_duration_seconds_sum{op="get"} 5.55` +"\n",
+    `test_duration_seconds_count{op="get"} 3` +"\n",
+  }
+  
+  for _, e := range expect {
+    if !strings.Contains(out, e) {
+      t.Errorf("Expected %q in output:\n%s", e, out)
+    }
+  }
+  
+  // buckets can't be changed once a histogram has observations
+  if m.SetBuckets("test_duration_seconds", []float64{1, 2, 3, 4}) {
+    t.Errorf("Expected buckets not to be changed")
+  }
+  m.Observe("test_duration_seconds", 0.5, "op", "get")
+  m.Observe("test_duration_seconds", 0.5, "op", "put")
+  
+  b = &strings.Builder{}
+  m.WriteTo(b)
+  out = b.String()
+  if e := `test_duration_seconds_bucket{op="put",le="1"} 1` +"\n"; !strings.Contains(out, e) {
+    t.Errorf("Expected %q in output:\n%s", e, out)
+  }
+  if e := `le="2"`; strings.Contains(out, e) {
+    t.Errorf("Expected no %q in output:\n%s", e, out)
+  }
+  
+}
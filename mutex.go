@@ -0,0 +1,220 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "sync"
+  "time"
+  "errors"
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+)
+
+var LockHeldError     = errors.New("Lock is already held")
+var LockNotHeldError  = errors.New("Lock is not held")
+
+/**
+ * A distributed mutex backed by etcd. The lock is held by creating a key with a TTL
+ * which only succeeds if the key does not already exist. While the lock is held its
+ * TTL is refreshed automatically so it only expires if the holder goes away.
+ */
+type Mutex struct {
+  state       sync.Mutex
+  config      *EtcdConfig
+  key         string
+  value       string
+  ttl         time.Duration
+  local       chan struct{}
+  held        bool
+  stop        chan struct{}
+  lost        chan struct{}
+}
+
+/**
+ * Create a mutex which is held by way of the provided key. The TTL determines how long
+ * the lock survives if its holder stops refreshing it; it must be at least one second.
+ */
+func NewMutex(config *EtcdConfig, key string, ttl time.Duration) *Mutex {
+  return newMutex(config, key, newLockToken(), ttl)
+}
+
+/**
+ * Create a mutex which is held by way of the provided key and which stores the provided
+ * value when it is held. The value must be unique to the holder.
+ */
+func newMutex(config *EtcdConfig, key, value string, ttl time.Duration) *Mutex {
+  return &Mutex{config:config, key:key, value:value, ttl:ttl, local:make(chan struct{}, 1)}
+}
+
+/**
+ * Obtain the key which backs this mutex
+ */
+func (m *Mutex) Key() string {
+  return m.key
+}
+
+/**
+ * Acquire the lock. This method blocks until the lock is acquired or the context is
+ * canceled. Rather than polling, we wait on the lock key until its current holder
+ * releases it or it expires and then try again.
+ */
+func (m *Mutex) Lock(ctx context.Context) error {
+  if m.ttl < time.Second {
+    return InvalidTTLError
+  }
+  
+  // only one local caller may attempt to hold the lock at a time
+  select {
+    case m.local <- struct{}{}:
+      // proceed
+    case <- ctx.Done():
+      return ctx.Err()
+  }
+  
+  var acquired time.Time
+  for {
+    acquired = time.Now() // the TTL runs from no earlier than when we asked
+    _, err := m.config.set(ctx, m.key, "PUT", false, m.value, nil, -1, m.ttl, false, 0)
+    if err == nil {
+      break
+    }else if err != KeyCollisionError {
+      <- m.local
+      return err
+    }
+    err = m.config.waitForRemoval(ctx, m.key)
+    if err != nil {
+      <- m.local
+      return err
+    }
+  }
+  
+  m.state.Lock()
+  defer m.state.Unlock()
+  m.held = true
+  m.stop = make(chan struct{})
+  m.lost = make(chan struct{})
+  go m.refresh(m.stop, m.lost, acquired)
+  
+  return nil
+}
+
+/**
+ * Release the lock. The lock key is deleted only if it still holds our value, so a lock
+ * which has expired and been acquired by someone else in the meantime is left alone.
+ */
+func (m *Mutex) Unlock(ctx context.Context) error {
+  m.state.Lock()
+  if !m.held {
+    m.state.Unlock()
+    return LockNotHeldError
+  }
+  m.held = false
+  close(m.stop)
+  m.state.Unlock()
+  
+  defer func(){ <- m.local }()
+  
+  _, err := m.config.delete(ctx, m.key, m.value, 0)
+  if err != nil {
+    return err
+  }
+  
+  return nil
+}
+
+/**
+ * Obtain a channel which is closed if the lock is lost while it is held (e.g., because
+ * it could not be refreshed before it expired). If the lock is not held, nil is returned.
+ */
+func (m *Mutex) Lost() <-chan struct{} {
+  m.state.Lock()
+  defer m.state.Unlock()
+  if !m.held {
+    return nil
+  }
+  return m.lost
+}
+
+/**
+ * Refresh the lock TTL until we are stopped or the lock is lost. The lock is lost if the
+ * key no longer holds our value or if a full TTL has passed since it was last refreshed,
+ * in which case it must be assumed to have expired even if we can't reach the cluster to
+ * find out. Each attempt is limited so a single slow request can't use up the TTL.
+ */
+func (m *Mutex) refresh(stop, lost chan struct{}, last time.Time) {
+  wait := m.ttl / 3
+  for {
+    
+    select {
+      case <- stop:
+        return
+      case <- time.After(wait):
+        // refresh
+    }
+    
+    start := time.Now()
+    ctx, cancel := context.WithDeadline(context.Background(), last.Add(m.ttl))
+    _, err := m.config.set(ctx, m.key, "PUT", false, nil, m.value, 0, m.ttl, true, m.ttl / 4)
+    cancel()
+    if err == nil {
+      last, wait = start, m.ttl / 3
+      continue
+    }
+    
+    if err == ComparisonFailedError || err == NoSuchKeyError {
+      m.config.logger.Logf(LevelError, "[%s] Lock was lost: %v", m.key, err)
+      close(lost)
+      return
+    }else if time.Since(last) >= m.ttl {
+      m.config.logger.Logf(LevelError, "[%s] Lock was lost; it could not be refreshed before it expired: %v", m.key, err)
+      close(lost)
+      return
+    }
+    
+    // try again sooner than usual, since the lock is running out
+    m.config.logger.Logf(LevelWarn, "[%s] Could not refresh lock (will retry): %v", m.key, err)
+    wait = m.ttl / 10
+    
+  }
+}
+
+/**
+ * Generate a token which uniquely identifies a lock holder
+ */
+func newLockToken() string {
+  b := make([]byte, 16)
+  _, err := rand.Read(b)
+  if err != nil {
+    panic(err)
+  }
+  return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+/**
+ * A read-only configuration
+ */
+type readOnlyConfig struct {
+  config  Config
+}
+
+/**
+ * A read-only configuration which can be observed
+ */
+type observableReadOnlyConfig struct {
+  *readOnlyConfig
+}
+
+/**
+ * Create a read-only view of a configuration. Reads and watches pass through to the
+ * underlying configuration, but any attempt to modify it fails with ReadOnlyError. The
+ * result is Observable only if the underlying configuration is.
+ */
+func ReadOnly(config Config) Config {
+  switch c := config.(type) {
+    case *readOnlyConfig, *observableReadOnlyConfig:
+      return c
+  }
+  c := &readOnlyConfig{config}
+  if _, ok := config.(Observable); ok {
+    return &observableReadOnlyConfig{c}
+  }
+  return c
+}
+
+/**
+ * Obtain a configuration value.
+ */
+func (c *readOnlyConfig) Get(key string) (interface{}, error) {
+  return c.config.Get(key)
+}
+
+/**
+ * Setting a value is not permitted.
+ */
+func (c *readOnlyConfig) Set(key string, value interface{}) (interface{}, error) {
+  return nil, ReadOnlyError
+}
+
+/**
+ * Deleting a value is not permitted.
+ */
+func (c *readOnlyConfig) Delete(key string) error {
+  return ReadOnlyError
+}
+
+/**
+ * Watch a configuration value for changes asynchronously.
+ */
+func (c *observableReadOnlyConfig) Watch(key string, observer Observer) {
+  c.config.(Observable).Watch(key, observer)
+}
+
+/**
+ * Describe where a key is defined, if the underlying configuration can.
+ */
+func (c *readOnlyConfig) Locate(key string) (*Location, error) {
+  if l, ok := c.config.(Locator); ok {
+    return l.Locate(key)
+  }
+  return nil, nil
+}
+
+/**
+ * List keys, if the underlying configuration can.
+ */
+func (c *readOnlyConfig) Keys(prefix string) ([]string, error) {
+  if l, ok := c.config.(Lister); ok {
+    return l.Keys(prefix)
+  }
+  return nil, NotListableError
+}
+
+/**
+ * List values, if the underlying configuration can.
+ */
+func (c *readOnlyConfig) Values(prefix string) (map[string]interface{}, error) {
+  if l, ok := c.config.(ValueLister); ok {
+    return l.Values(prefix)
+  }
+  return nil, NotListableError
+}
+
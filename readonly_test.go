@@ -0,0 +1,84 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "testing"
+)
+
+func TestReadOnly(t *testing.T) {
+  
+  m := NewMemoryConfig(map[string]interface{}{"key": "Value"})
+  r := ReadOnly(m)
+  
+  if v, err := r.Get("key"); err != nil || v != "Value" {
+    t.Errorf("Unexpected value: %v, %v", v, err)
+  }
+  if _, err := r.Set("key", "Updated"); err != ReadOnlyError {
+    t.Errorf("Expected a read-only error: %v", err)
+  }
+  if err := r.Delete("key"); err != ReadOnlyError {
+    t.Errorf("Expected a read-only error: %v", err)
+  }
+  if v, _ := m.Get("key"); v != "Value" {
+    t.Errorf("Configuration was modified: %v", v)
+  }
+  
+  w := make(chan interface{}, 1)
+  r.(Observable).Watch("key", func(key string, value interface{}) {
+    w <- value
+  })
+  m.Set("key", "Updated")
+  if v := <- w; v != "Updated" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // a view of a configuration which can't be observed can't be either
+  if _, ok := ReadOnly(struct{ Config }{m}).(Observable); ok {
+    t.Errorf("Read-only view should not be observable")
+  }
+  if ReadOnly(r) != r {
+    t.Errorf("Read-only view should not be wrapped again")
+  }
+  
+  // a suite skips layers which are read-only
+  b := NewMemoryConfig(nil)
+  s := NewConfigSuite(r, b)
+  s.SetWritePolicy(WriteFirstWritable)
+  if _, err := s.Set("other", "Value"); err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v, _ := b.Get("other"); v != "Value" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+}
+
@@ -0,0 +1,371 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "sort"
+  "sync"
+  "time"
+  "errors"
+  "strings"
+  "context"
+)
+
+var InvalidInstanceError = errors.New("Invalid instance identifier")
+
+/**
+ * A service instance
+ */
+type Instance struct {
+  Id          string
+  Value       string
+}
+
+/**
+ * A service registry backed by etcd. Instances of a service are registered as keys in
+ * that service's directory under the registry prefix (i.e., "prefix.service.id"). Each
+ * instance key has a TTL which is refreshed by a heartbeat for as long as the instance
+ * is registered, so an instance which goes away without deregistering expires.
+ */
+type Registry struct {
+  config      *EtcdConfig
+  prefix      string
+  ttl         time.Duration
+}
+
+/**
+ * Create a registry under the provided key prefix. The TTL determines how long an
+ * instance survives if its heartbeat stops; it must be at least one second.
+ */
+func NewRegistry(config *EtcdConfig, prefix string, ttl time.Duration) *Registry {
+  return &Registry{config:config, prefix:prefix, ttl:ttl}
+}
+
+/**
+ * Obtain the directory key for a service
+ */
+func (r *Registry) serviceKey(service string) string {
+  if r.prefix == "" {
+    return service
+  }else{
+    return r.prefix +"."+ service
+  }
+}
+
+/**
+ * Register an instance of a service. The instance identifier must be unique within the
+ * service and may not contain '.', which is the key separator. The instance remains
+ * registered until it is deregistered or its heartbeat stops.
+ */
+func (r *Registry) Register(ctx context.Context, service, id, value string) (*Registration, error) {
+  if r.ttl < time.Second {
+    return nil, InvalidTTLError
+  }
+  if id == "" || strings.Contains(id, ".") {
+    return nil, InvalidInstanceError
+  }
+  
+  key := r.serviceKey(service) +"."+ id
+  _, err := r.config.set(ctx, key, "PUT", false, value, nil, 0, r.ttl, false, 0)
+  if err != nil {
+    return nil, err
+  }
+  
+  g := &Registration{registry:r, key:key, value:value, stop:make(chan struct{})}
+  go g.heartbeat()
+  
+  return g, nil
+}
+
+/**
+ * List the instances of a service which are currently registered
+ */
+func (r *Registry) Instances(service string) ([]*Instance, error) {
+  rsp, err := r.config.get(context.Background(), r.serviceKey(service), false, false, 0, 0)
+  if err == NoSuchKeyError {
+    return []*Instance{}, nil
+  }else if err != nil {
+    return nil, err
+  }
+  return instancesFromNode(rsp.Node), nil
+}
+
+/**
+ * Watch the instances of a service. The returned set is kept current as instances come
+ * and go, which makes it suitable for client-side load balancing.
+ */
+func (r *Registry) Watch(service string) (*InstanceSet, error) {
+  key := r.serviceKey(service)
+  
+  // make sure the service directory exists so we know which index to watch from
+  _, err := r.config.set(context.Background(), key, "PUT", true, nil, nil, -1, 0, false, 0)
+  if err != nil && err != KeyCollisionError {
+    return nil, err
+  }
+  
+  set := &InstanceSet{config:r.config, key:key, instances:make(map[string]*Instance), indexes:make(map[string]int64)}
+  rsp, err := r.config.get(context.Background(), key, false, false, 0, 0)
+  if err == nil {
+    for _, n := range rsp.Node.Subnodes {
+      if !n.Directory {
+        id := etcdNodeName(n.Key)
+        set.instances[id] = &Instance{Id:id, Value:n.Encoded}
+        set.indexes[id] = n.Modified
+      }
+    }
+  }else if err != NoSuchKeyError {
+    return nil, err
+  }
+  
+  // continue watching from the directory state we just read, if any
+  r.config.cache.AddDirectoryObserver(key, rsp, set.update)
+  
+  return set, nil
+}
+
+/**
+ * Obtain instances from a service directory node
+ */
+func instancesFromNode(node *etcdNode) []*Instance {
+  instances := make([]*Instance, 0)
+  if node != nil {
+    for _, n := range node.Subnodes {
+      if !n.Directory {
+        instances = append(instances, &Instance{Id:etcdNodeName(n.Key), Value:n.Encoded})
+      }
+    }
+  }
+  sort.Sort(instancesById(instances))
+  return instances
+}
+
+/**
+ * Obtain the last component of a node key
+ */
+func etcdNodeName(path string) string {
+  key := etcdPathToKey(path)
+  if i := strings.LastIndex(key, "."); i >= 0 {
+    return key[i+1:]
+  }else{
+    return key
+  }
+}
+
+/**
+ * A registered service instance
+ */
+type Registration struct {
+  registry    *Registry
+  key         string
+  value       string
+  stop        chan struct{}
+  once        sync.Once
+}
+
+/**
+ * Deregister this instance. The instance key is deleted only if it still holds our value.
+ */
+func (g *Registration) Deregister(ctx context.Context) error {
+  g.once.Do(func(){ close(g.stop) })
+  _, err := g.registry.config.delete(ctx, g.key, g.value, 0)
+  if err != nil && err != NoSuchKeyError {
+    return err
+  }
+  return nil
+}
+
+/**
+ * Refresh our TTL until we are deregistered. If our key expired in the meantime (e.g.,
+ * because we were partitioned from etcd for longer than the TTL) we register again.
+ */
+func (g *Registration) heartbeat() {
+  r := g.registry
+  for {
+    
+    select {
+      case <- g.stop:
+        return
+      case <- time.After(r.ttl / 3):
+        // refresh
+    }
+    
+    _, err := r.config.set(context.Background(), g.key, "PUT", false, nil, nil, 0, r.ttl, true, 0)
+    if err == NoSuchKeyError {
+      r.config.logger.Logf(LevelWarn, "[%s] Registration expired; registering again", g.key)
+      _, err = r.config.set(context.Background(), g.key, "PUT", false, g.value, nil, 0, r.ttl, false, 0)
+    }
+    if err != nil {
+      r.config.logger.Logf(LevelWarn, "[%s] Could not refresh registration (will retry): %v", g.key, err)
+    }
+    
+  }
+}
+
+/**
+ * A set of service instances which is kept current by watching the service directory
+ */
+type InstanceSet struct {
+  sync.RWMutex
+  config      *EtcdConfig
+  key         string
+  instances   map[string]*Instance
+  indexes     map[string]int64
+  cleared     int64
+  next        int
+}
+
+/**
+ * Obtain the instances currently in the set, ordered by identifier
+ */
+func (s *InstanceSet) Instances() []*Instance {
+  s.RLock()
+  defer s.RUnlock()
+  return s.list()
+}
+
+/**
+ * Obtain the instances currently in the set (no sync)
+ */
+func (s *InstanceSet) list() []*Instance {
+  instances := make([]*Instance, 0, len(s.instances))
+  for _, e := range s.instances {
+    instances = append(instances, e)
+  }
+  sort.Sort(instancesById(instances))
+  return instances
+}
+
+/**
+ * Obtain the next instance in round-robin order. If there are no instances, false is
+ * returned.
+ */
+func (s *InstanceSet) Next() (*Instance, bool) {
+  s.Lock()
+  defer s.Unlock()
+  instances := s.list()
+  if len(instances) < 1 {
+    return nil, false
+  }
+  s.next = (s.next + 1) % len(instances)
+  return instances[s.next], true
+}
+
+/**
+ * Apply a change to the service directory. Observers are notified concurrently, so
+ * changes may arrive out of order; we use their indexes to discard anything older than
+ * what we already know about.
+ */
+func (s *InstanceSet) update(event *Event) {
+  if event.Action == ActionResync {
+    s.resync(event)
+    return
+  }
+  
+  s.Lock()
+  defer s.Unlock()
+  
+  if event.Index <= s.cleared {
+    return
+  }
+  
+  // the directory itself was removed
+  if event.Key == s.key {
+    switch event.Action {
+      case ActionDelete, ActionExpire, ActionCompareAndDelete:
+        s.instances = make(map[string]*Instance)
+        s.indexes = make(map[string]int64)
+        s.cleared = event.Index
+    }
+    return
+  }
+  
+  // otherwise, only direct descendants are instances
+  id := strings.TrimPrefix(event.Key, s.key +".")
+  if id == event.Key || strings.Contains(id, ".") {
+    return
+  }
+  
+  if event.Index <= s.indexes[id] {
+    return
+  }
+  s.indexes[id] = event.Index
+  
+  switch event.Action {
+    case ActionDelete, ActionExpire, ActionCompareAndDelete:
+      delete(s.instances, id)
+    default:
+      s.instances[id] = &Instance{Id:id, Value:encodeValue(event.Value)}
+  }
+  
+}
+
+/**
+ * Replace the set with the current state of the service directory. This happens when our
+ * watch fell too far behind to know what changed in the meantime.
+ */
+func (s *InstanceSet) resync(event *Event) {
+  
+  rsp, err := s.config.get(context.Background(), s.key, false, false, 0, 0)
+  if err != nil && err != NoSuchKeyError {
+    s.config.logger.Logf(LevelError, "[%s] Could not resynchronize instances: %v", s.key, err)
+    return
+  }
+  
+  instances := make(map[string]*Instance)
+  indexes := make(map[string]int64)
+  if err == nil {
+    for _, n := range rsp.Node.Subnodes {
+      if !n.Directory {
+        id := etcdNodeName(n.Key)
+        instances[id] = &Instance{Id:id, Value:n.Encoded}
+        indexes[id] = n.Modified
+      }
+    }
+  }
+  
+  s.Lock()
+  defer s.Unlock()
+  if event.Index > s.cleared {
+    s.instances = instances
+    s.indexes = indexes
+    s.cleared = event.Index
+  }
+  
+}
+
+/**
+ * Sort instances by identifier
+ */
+type instancesById []*Instance
+
+func (v instancesById) Len() int            { return len(v) }
+func (v instancesById) Swap(a, b int)       { v[a], v[b] = v[b], v[a] }
+func (v instancesById) Less(a, b int) bool  { return v[a].Id < v[b].Id }
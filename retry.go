@@ -0,0 +1,197 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "net"
+  "time"
+  "errors"
+  "context"
+  "syscall"
+  "net/url"
+  "math/rand"
+  "crypto/tls"
+  "crypto/x509"
+)
+
+/**
+ * A retry policy decides whether and when an operation which failed is attempted again
+ */
+type RetryPolicy interface {
+  
+  /**
+   * Determine how long to wait before retrying an operation which failed with the
+   * provided error. Attempts are counted from 1, which is the first retry. If the
+   * operation should not be retried, false is returned.
+   */
+  Backoff(attempt int, err error) (time.Duration, bool)
+  
+}
+
+/**
+ * A policy which never retries
+ */
+var NoRetry RetryPolicy = noRetry{}
+
+type noRetry struct {}
+
+func (p noRetry) Backoff(attempt int, err error) (time.Duration, bool) {
+  return 0, false
+}
+
+/**
+ * An exponential backoff policy. The delay doubles with each attempt, beginning with the
+ * base delay, up to the maximum delay. Each delay is randomized by the jitter factor
+ * (e.g., 0.2 produces delays within 20% of the nominal delay either way) so that clients
+ * which failed at the same time don't all retry at the same time.
+ */
+type ExponentialBackoff struct {
+  Base          time.Duration
+  Max           time.Duration
+  Jitter        float64
+  MaxAttempts   int               // zero means no limit
+  Retryable     func(error) bool  // nil means IsRetryable
+}
+
+/**
+ * Determine how long to wait before the provided attempt
+ */
+func (p *ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+  if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+    return 0, false
+  }
+  
+  retryable := p.Retryable
+  if retryable == nil {
+    retryable = IsRetryable
+  }
+  if !retryable(err) {
+    return 0, false
+  }
+  
+  delay := p.Base
+  for i := 1; i < attempt && (p.Max <= 0 || delay < p.Max); i++ {
+    delay *= 2
+  }
+  if p.Max > 0 && delay > p.Max {
+    delay = p.Max
+  }
+  
+  if p.Jitter > 0 {
+    delay += time.Duration(float64(delay) * p.Jitter * (rand.Float64() * 2 - 1))
+  }
+  
+  return delay, true
+}
+
+/**
+ * The default policy for requests
+ */
+func defaultRequestRetryPolicy() RetryPolicy {
+  return &ExponentialBackoff{Base:time.Millisecond * 100, Max:time.Second * 2, Jitter:0.2, MaxAttempts:3}
+}
+
+/**
+ * The default policy for watches, which never give up
+ */
+func defaultWatchRetryPolicy() RetryPolicy {
+  return &ExponentialBackoff{Base:time.Second, Max:time.Second * 15, Jitter:0.2, Retryable:func(error) bool { return true }}
+}
+
+/**
+ * Determine if an error is transient and an operation which failed with it may succeed
+ * if it is attempted again. Errors which describe the outcome of an operation (e.g., a
+ * missing key or a failed comparison) are never retryable.
+ */
+func IsRetryable(err error) bool {
+  switch err {
+    case nil, NoSuchKeyError, KeyCollisionError, ComparisonFailedError, InvalidIndexError, InvalidTTLError:
+      return false
+    case context.Canceled, context.DeadlineExceeded:
+      return false
+    case TimeoutError, ServiceError, io.EOF, io.ErrUnexpectedEOF:
+      return true
+  }
+  
+  var etcerr *etcdError
+  if errors.As(err, &etcerr) {
+    return etcerr.Code >= 300 && etcerr.Code < 400 // raft and leader election errors
+  }
+  
+  // the client wraps everything in a URL error, which is itself a net.Error; we look at
+  // what actually went wrong instead
+  var urlerr *url.Error
+  if errors.As(err, &urlerr) {
+    err = urlerr.Err
+  }
+  
+  if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+    return true
+  }
+  
+  // certificate problems are a matter of configuration and will fail the same way
+  // every time
+  var verr *tls.CertificateVerificationError
+  var aerr x509.UnknownAuthorityError
+  var herr x509.HostnameError
+  var cerr x509.CertificateInvalidError
+  if errors.As(err, &verr) || errors.As(err, &aerr) || errors.As(err, &herr) || errors.As(err, &cerr) {
+    return false
+  }
+  
+  var operr *net.OpError
+  if errors.As(err, &operr) {
+    return true // dialing, reading or writing failed
+  }
+  var neterr net.Error
+  if errors.As(err, &neterr) && neterr.Timeout() {
+    return true
+  }
+  
+  return false
+}
+
+/**
+ * Wait for a backoff delay. If the context would expire before the delay elapses there
+ * is no point in waiting, so we give up right away.
+ */
+func waitForRetry(ctx context.Context, delay time.Duration) bool {
+  if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+    return false
+  }
+  select {
+    case <- time.After(delay):
+      return true
+    case <- ctx.Done():
+      return false
+  }
+}
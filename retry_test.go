@@ -0,0 +1,134 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "io"
+  "net"
+  "time"
+  "context"
+  "testing"
+  "syscall"
+  "net/url"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+  
+  p := &ExponentialBackoff{Base:time.Millisecond * 100, Max:time.Second, MaxAttempts:5}
+  expect := []time.Duration{
+    time.Millisecond * 100,
+    time.Millisecond * 200,
+    time.Millisecond * 400,
+    time.Millisecond * 800,
+    time.Second,
+  }
+  
+  for i, e := range expect {
+    d, ok := p.Backoff(i + 1, TimeoutError)
+    if !ok {
+      t.Errorf("Attempt %d should be retried", i + 1)
+    }else if d != e {
+      t.Errorf("Attempt %d: expected %v, got %v", i + 1, e, d)
+    }
+  }
+  
+  if _, ok := p.Backoff(len(expect) + 1, TimeoutError); ok {
+    t.Errorf("Attempts should be exhausted")
+  }
+  
+  if _, ok := p.Backoff(1, ComparisonFailedError); ok {
+    t.Errorf("Comparison failures should not be retried")
+  }
+  
+  p = &ExponentialBackoff{Base:time.Second, Jitter:0.5}
+  for i := 0; i < 100; i++ {
+    d, _ := p.Backoff(1, io.EOF)
+    if d < time.Millisecond * 500 || d > time.Millisecond * 1500 {
+      t.Errorf("Delay is out of range: %v", d)
+    }
+  }
+  
+}
+
+func TestRetryDeadline(t *testing.T) {
+  
+  ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 100)
+  defer cancel()
+  
+  if waitForRetry(ctx, time.Second) {
+    t.Errorf("Should not wait past the deadline")
+  }
+  if !waitForRetry(ctx, time.Millisecond) {
+    t.Errorf("Should wait within the deadline")
+  }
+  
+}
+
+func TestIsRetryable(t *testing.T) {
+  
+  s := httptest.NewTLSServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {}))
+  defer s.Close()
+  
+  // the certificate is not trusted by the default client
+  _, err := http.Get(s.URL)
+  if err == nil || IsRetryable(err) {
+    t.Errorf("Untrusted certificate should not be retryable: %v", err)
+  }
+  
+  _, err = http.Get("ftp://localhost/")
+  if err == nil || IsRetryable(err) {
+    t.Errorf("Unsupported scheme should not be retryable: %v", err)
+  }
+  
+  _, err = http.Get("http://localhost:1/")
+  if err == nil || !IsRetryable(err) {
+    t.Errorf("Refused connection should be retryable: %v", err)
+  }
+  
+  err = &url.Error{Op:"Get", URL:"http://localhost/", Err:&net.OpError{Op:"read", Net:"tcp", Err:syscall.ECONNRESET}}
+  if !IsRetryable(err) {
+    t.Errorf("Reset connection should be retryable: %v", err)
+  }
+  
+  for _, e := range []error{TimeoutError, ServiceError, io.EOF} {
+    if !IsRetryable(e) {
+      t.Errorf("Should be retryable: %v", e)
+    }
+  }
+  for _, e := range []error{NoSuchKeyError, ComparisonFailedError, context.Canceled} {
+    if IsRetryable(e) {
+      t.Errorf("Should not be retryable: %v", e)
+    }
+  }
+  
+}
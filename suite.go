@@ -1,6 +1,6 @@
 // 
 // Go Config
-// Copyright (c) 2014 Brian W. Wolter, All rights reserved.
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
 // 
 // Redistribution and use in source and binary forms, with or without modification,
 // are permitted provided that the following conditions are met:
@@ -30,12 +30,42 @@
 
 package conf
 
+import (
+  "errors"
+)
+
+var NoSuchLayerError = errors.New("No such layer")
+
+/**
+ * Write policies, which determine the layers of a suite that are written to
+ */
+type WritePolicy int
+
+const (
+  WriteBroadcast      WritePolicy = iota  // write to every layer which is not read-only
+  WriteFirstWritable                      // write to the highest priority layer which is not read-only
+  WriteNamed                              // write to a particular layer
+)
+
+/**
+ * A layer of a configuration suite. A layer which is read-only is never written to by
+ * the suite.
+ */
+type Layer struct {
+  Name      string
+  Config    Config
+  ReadOnly  bool
+}
+
 /**
  * A configuration suite. A suite represents a number of underlying configurations
  * which are organized in order of their priority.
  */
 type ConfigSuite struct {
-  suite   []Config
+  suite   []Layer
+  policy  WritePolicy
+  target  string
+  merge   *MergeOptions
 }
 
 /**
@@ -43,16 +73,55 @@ type ConfigSuite struct {
  * of their priority from highest to lowest.
  */
 func NewConfigSuite(c ...Config) *ConfigSuite {
-  return &ConfigSuite{c}
+  layers := make([]Layer, len(c))
+  for i, e := range c {
+    layers[i] = Layer{Config:e}
+  }
+  return &ConfigSuite{suite:layers}
+}
+
+/**
+ * Create a config suite representing the provided layers, in order of their priority
+ * from highest to lowest.
+ */
+func NewConfigSuiteWithLayers(layers ...Layer) *ConfigSuite {
+  return &ConfigSuite{suite:layers}
+}
+
+/**
+ * Set the policy which determines the layers that are written to. By default, writes
+ * are broadcast to every layer which is not read-only.
+ */
+func (s *ConfigSuite) SetWritePolicy(policy WritePolicy) {
+  s.policy = policy
+}
+
+/**
+ * Write only to the named layer
+ */
+func (s *ConfigSuite) SetWriteLayer(name string) {
+  s.policy = WriteNamed
+  s.target = name
+}
+
+/**
+ * Merge structured values across layers rather than using the highest priority value
+ * as-is. If the options are nil, values are not merged.
+ */
+func (s *ConfigSuite) SetMerge(opts *MergeOptions) {
+  s.merge = opts
 }
 
 /**
  * Obtain a configuration value.
  */
 func (s *ConfigSuite) Get(key string) (interface{}, error) {
+  if s.merge != nil {
+    return s.getMerged(key)
+  }
   if s.suite != nil {
-    for _, c := range s.suite {
-      v, err := c.Get(key)
+    for _, l := range s.suite {
+      v, err := l.Config.Get(key)
       if err == nil {
         return v, nil
       }else if err != NoSuchKeyError {
@@ -63,35 +132,147 @@ func (s *ConfigSuite) Get(key string) (interface{}, error) {
   return nil, NoSuchKeyError
 }
 
+/**
+ * Obtain a configuration value which is merged across layers. Layers are read from the
+ * highest priority down until one defines a value which can't be merged with those below
+ * it, so a layer which fails only matters if its value would have been used.
+ */
+func (s *ConfigSuite) getMerged(key string) (interface{}, error) {
+  var vals []interface{}
+  for _, l := range s.suite {
+    v, err := l.Config.Get(key)
+    if err == NoSuchKeyError {
+      continue
+    }else if err != nil {
+      return nil, err
+    }
+    vals = append(vals, v)
+    if !isStructured(v) {
+      break
+    }
+  }
+  if len(vals) == 0 {
+    return nil, NoSuchKeyError
+  }
+  res := vals[len(vals) - 1]
+  for i := len(vals) - 2; i >= 0; i-- {
+    res = s.merge.merge(res, vals[i])
+  }
+  return res, nil
+}
+
+/**
+ * List the keys which are equal to or under the provided prefix in any layer. Layers
+ * which cannot be listed are ignored; if no layer can be listed, NotListableError is
+ * returned.
+ */
+func (s *ConfigSuite) Keys(prefix string) ([]string, error) {
+  var listed bool
+  seen := make(map[string]struct{})
+  keys := make([]string, 0)
+  for _, l := range s.suite {
+    c, ok := l.Config.(Lister)
+    if !ok {
+      continue
+    }
+    k, err := c.Keys(prefix)
+    if err == NotListableError {
+      continue
+    }else if err != nil {
+      return nil, err
+    }
+    listed = true
+    for _, e := range k {
+      if _, ok := seen[e]; !ok {
+        seen[e] = struct{}{}
+        keys = append(keys, e)
+      }
+    }
+  }
+  if !listed {
+    return nil, NotListableError
+  }
+  return keys, nil
+}
+
 /**
  * Set a configuration value. The canonical form of the value is returned.
  */
 func (s *ConfigSuite) Set(key string, value interface{}) (interface{}, error) {
   var first interface{}
-  if s.suite != nil {
-    for _, c := range s.suite {
-      v, err := c.Set(key, value)
-      if err != nil {
-        return nil, err
-      }else if first == nil {
-        first = v
-      }
+  err := s.write(func(c Config) error {
+    v, err := c.Set(key, value)
+    if err == nil && first == nil {
+      first = v
     }
+    return err
+  })
+  if err != nil {
+    return nil, err
   }
   return first, nil
 }
 
 /**
- * Delete a configuration key/value.
+ * Delete a configuration key/value. When writes are not broadcast, lower priority
+ * layers are not affected, so they may still provide a value for the key.
  */
 func (s *ConfigSuite) Delete(key string) error {
-  if s.suite != nil {
-    for _, c := range s.suite {
-      err := c.Delete(key)
-      if err != nil {
-        return err
+  return s.write(func(c Config) error {
+    return c.Delete(key)
+  })
+}
+
+/**
+ * Apply a write to the layers selected by our write policy. A layer whose configuration
+ * reports that it's read-only is treated as a read-only layer. If no layer can be
+ * written to, ReadOnlyError is returned.
+ */
+func (s *ConfigSuite) write(f func(Config) error) error {
+  switch s.policy {
+    
+    case WriteNamed:
+      for _, l := range s.suite {
+        if l.Name == s.target {
+          if l.ReadOnly {
+            return ReadOnlyError
+          }
+          return f(l.Config)
+        }
       }
-    }
+      return NoSuchLayerError
+      
+    case WriteFirstWritable:
+      for _, l := range s.suite {
+        if !l.ReadOnly {
+          err := f(l.Config)
+          if err != ReadOnlyError {
+            return err
+          }
+        }
+      }
+      return ReadOnlyError
+      
+    default:
+      var written, skipped bool
+      for _, l := range s.suite {
+        if l.ReadOnly {
+          skipped = true
+          continue
+        }
+        err := f(l.Config)
+        if err == ReadOnlyError {
+          skipped = true
+        }else if err != nil {
+          return err
+        }else{
+          written = true
+        }
+      }
+      if skipped && !written {
+        return ReadOnlyError
+      }
+      return nil
+      
   }
-  return nil
 }
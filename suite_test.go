@@ -0,0 +1,119 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "testing"
+)
+
+func TestSuiteWritePolicies(t *testing.T) {
+  
+  newSuite := func() (*ConfigSuite, *MemoryConfig, *MemoryConfig, *MemoryConfig) {
+    a := NewMemoryConfig(nil)
+    b := NewMemoryConfig(map[string]interface{}{"key": "File"})
+    c := NewMemoryConfig(map[string]interface{}{"key": "Env"})
+    return NewConfigSuiteWithLayers(Layer{"runtime", a, false}, Layer{"file", b, false}, Layer{"env", c, true}), a, b, c
+  }
+  
+  // broadcast writes to every writable layer
+  s, a, b, c := newSuite()
+  _, err := s.Set("key", "Override")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v, _ := a.Get("key"); v != "Override" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if v, _ := b.Get("key"); v != "Override" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if v, _ := c.Get("key"); v != "Env" {
+    t.Errorf("Read-only layer was modified: %v", v)
+  }
+  
+  // first writable only writes to the highest priority layer
+  s, a, b, c = newSuite()
+  s.SetWritePolicy(WriteFirstWritable)
+  _, err = s.Set("key", "Override")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if v, _ := s.Get("key"); v != "Override" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  if v, _ := b.Get("key"); v != "File" {
+    t.Errorf("Lower layer was modified: %v", v)
+  }
+  err = s.Delete("key")
+  if err != nil {
+    t.Errorf("Could not delete: %v", err)
+  }
+  if v, _ := s.Get("key"); v != "File" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  // named writes only to that layer
+  s, a, b, c = newSuite()
+  s.SetWriteLayer("file")
+  _, err = s.Set("key", "Updated")
+  if err != nil {
+    t.Errorf("Could not set: %v", err)
+  }
+  if _, err := a.Get("key"); err != NoSuchKeyError {
+    t.Errorf("Unexpected layer was modified: %v", err)
+  }
+  if v, _ := b.Get("key"); v != "Updated" {
+    t.Errorf("Unexpected value: %v", v)
+  }
+  
+  s.SetWriteLayer("env")
+  _, err = s.Set("key", "Updated")
+  if err != ReadOnlyError {
+    t.Errorf("Expected a read-only error: %v", err)
+  }
+  s.SetWriteLayer("nope")
+  err = s.Delete("key")
+  if err != NoSuchLayerError {
+    t.Errorf("Expected no such layer: %v", err)
+  }
+  
+  // a suite with only read-only layers can't be written to
+  s = NewConfigSuiteWithLayers(Layer{"env", c, true})
+  for _, p := range []WritePolicy{WriteBroadcast, WriteFirstWritable} {
+    s.SetWritePolicy(p)
+    _, err = s.Set("key", "Updated")
+    if err != ReadOnlyError {
+      t.Errorf("Expected a read-only error: %v", err)
+    }
+  }
+  
+}
+
@@ -32,7 +32,10 @@ package conf
 
 import (
   "fmt"
+  "time"
   "reflect"
+  "strconv"
+  "strings"
 )
 
 /**
@@ -49,11 +52,17 @@ func AsString(v interface{}) (string, error) {
 }
 
 /**
- * Convert a value to a number
+ * Convert a value to a number. Strings are parsed.
  */
 func AsInt(v interface{}) (int64, error) {
   z := reflect.ValueOf(v)
   switch z.Kind() {
+    case reflect.String:
+      n, err := strconv.ParseInt(strings.TrimSpace(z.String()), 10, 64)
+      if err != nil {
+        return 0, fmt.Errorf("Cannot parse %q as numeric", z.String())
+      }
+      return n, nil
     case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
       return int64(z.Int()), nil
     case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
@@ -66,11 +75,17 @@ func AsInt(v interface{}) (int64, error) {
 }
 
 /**
- * Convert a value to a number
+ * Convert a value to a number. Strings are parsed.
  */
 func AsFloat(v interface{}) (float64, error) {
   z := reflect.ValueOf(v)
   switch z.Kind() {
+    case reflect.String:
+      n, err := strconv.ParseFloat(strings.TrimSpace(z.String()), 64)
+      if err != nil {
+        return 0, fmt.Errorf("Cannot parse %q as numeric", z.String())
+      }
+      return n, nil
     case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
       return float64(z.Int()), nil
     case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
@@ -83,11 +98,17 @@ func AsFloat(v interface{}) (float64, error) {
 }
 
 /**
- * Convert a value to a bool
+ * Convert a value to a bool. Strings are parsed.
  */
 func AsBool(v interface{}) (bool, error) {
   z := reflect.ValueOf(v)
   switch z.Kind() {
+    case reflect.String:
+      b, err := strconv.ParseBool(strings.TrimSpace(z.String()))
+      if err != nil {
+        return false, fmt.Errorf("Cannot parse %q as bool", z.String())
+      }
+      return b, nil
     case reflect.Bool:
       return z.Bool(), nil
     case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -101,3 +122,34 @@ func AsBool(v interface{}) (bool, error) {
   }
 }
 
+/**
+ * Convert a value to a duration. Strings are parsed as durations (e.g., "1m30s") and
+ * plain numbers are interpreted as seconds.
+ */
+func AsDuration(v interface{}) (time.Duration, error) {
+  if d, ok := v.(time.Duration); ok {
+    return d, nil
+  }
+  z := reflect.ValueOf(v)
+  switch z.Kind() {
+    case reflect.String:
+      d, err := time.ParseDuration(strings.TrimSpace(z.String()))
+      if err == nil {
+        return d, nil
+      }
+      n, err := strconv.ParseFloat(strings.TrimSpace(z.String()), 64)
+      if err != nil {
+        return 0, fmt.Errorf("Cannot parse %q as duration", z.String())
+      }
+      return time.Duration(n * float64(time.Second)), nil
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      return time.Duration(z.Int()) * time.Second, nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+      return time.Duration(z.Uint()) * time.Second, nil
+    case reflect.Float32, reflect.Float64:
+      return time.Duration(z.Float() * float64(time.Second)), nil
+    default:
+      return 0, fmt.Errorf("Cannot cast (%T) %v to duration", v, v)
+  }
+}
+
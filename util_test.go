@@ -0,0 +1,76 @@
+// 
+// Go Config
+// Copyright (c) 2015 Brian W. Wolter, All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian W. Wolter nor the names of the contributors may
+//     be used to endorse or promote products derived from this software without
+//     specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package conf
+
+import (
+  "time"
+  "testing"
+)
+
+func TestConversions(t *testing.T) {
+  
+  if v, err := AsInt("42"); err != nil || v != 42 {
+    t.Errorf("Unexpected conversion: %v, %v", v, err)
+  }
+  if v, err := AsInt("08"); err != nil || v != 8 {
+    t.Errorf("Unexpected conversion: %v, %v", v, err)
+  }
+  if _, err := AsInt("Nope"); err == nil {
+    t.Errorf("Expected an error")
+  }
+  if v, err := AsFloat(" 1.5"); err != nil || v != 1.5 {
+    t.Errorf("Unexpected conversion: %v, %v", v, err)
+  }
+  if v, err := AsBool("true"); err != nil || !v {
+    t.Errorf("Unexpected conversion: %v, %v", v, err)
+  }
+  if _, err := AsBool("Nope"); err == nil {
+    t.Errorf("Expected an error")
+  }
+  
+  durations := map[interface{}]time.Duration{
+    "1m30s": time.Second * 90,
+    "2": time.Second * 2,
+    "0.5": time.Millisecond * 500,
+    3: time.Second * 3,
+    time.Millisecond: time.Millisecond,
+  }
+  for v, e := range durations {
+    if d, err := AsDuration(v); err != nil || d != e {
+      t.Errorf("Unexpected conversion of %v: %v, %v", v, d, err)
+    }
+  }
+  if _, err := AsDuration("Nope"); err == nil {
+    t.Errorf("Expected an error")
+  }
+  
+}
+